@@ -0,0 +1,241 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/nahrx/geomatis-api/storage"
+	"github.com/nahrx/geomatis-api/types"
+	"github.com/nahrx/geomatis-api/util"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+const (
+	defaultFeatureLimit = 100
+	maxFeatureLimit     = 1000
+)
+
+// query parameters of the features endpoints, any other parameter is an
+// attribute filter
+var featureQueryParams = map[string]bool{
+	"bbox":      true,
+	"bbox_srid": true,
+	"srid":      true,
+	"limit":     true,
+	"offset":    true,
+	"attr_key":  true,
+}
+
+func (s *Server) handleMasterMapFeatures(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+	switch r.Method {
+	case "GET":
+		return s.handleGetMasterMapFeatures(w, r)
+	case "OPTIONS":
+		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
+	}
+	return fmt.Errorf("Method not allowed")
+}
+
+func (s *Server) handleMasterMapFeatureByKey(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+	switch r.Method {
+	case "GET":
+		return s.handleGetMasterMapFeatureByKey(w, r)
+	case "POST", "PATCH":
+		return s.handleSaveMasterMapFeature(w, r)
+	case "DELETE":
+		return s.handleDeleteMasterMapFeature(w, r)
+	case "OPTIONS":
+		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
+	}
+	return fmt.Errorf("Method not allowed")
+}
+
+// handleGetMasterMapFeatures returns a page of features as a GeoJSON feature
+// collection, e.g. /master-maps/desa/features?kdkec=010&bbox=106.8,-6.3,106.9,-6.2&limit=50
+func (s *Server) handleGetMasterMapFeatures(w http.ResponseWriter, r *http.Request) error {
+	masterMap := mux.Vars(r)["name"]
+	if !util.AllNotNil(masterMap) {
+		return fmt.Errorf("API parameter is not complete.")
+	}
+	schema, err := s.store.GetMasterMapSchema(masterMap)
+	if err != nil {
+		return err
+	}
+	q, err := newFeatureQuery(r)
+	if err != nil {
+		return err
+	}
+	for key, values := range r.URL.Query() {
+		if !featureQueryParams[key] {
+			q.Filters[key] = values[0]
+		}
+	}
+	features, matched, err := s.store.GetMasterMapFeatures(schema, q)
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, newFeatureCollection(schema, q, features, matched))
+}
+
+// handleGetMasterMapFeatureByKey returns the feature whose attr_key attribute is
+// the {key} of the path.
+func (s *Server) handleGetMasterMapFeatureByKey(w http.ResponseWriter, r *http.Request) error {
+	masterMap, attrKey, key, err := featureKeyVars(r)
+	if err != nil {
+		return err
+	}
+	q, err := newFeatureQuery(r)
+	if err != nil {
+		return err
+	}
+	return s.writeFeature(w, http.StatusOK, masterMap, attrKey, key, q)
+}
+
+// handleSaveMasterMapFeature inserts (POST) or updates (PATCH) the feature of
+// the key. The body is a GeoJSON feature in the CRS of the master map, a PATCH
+// only changes the properties it sends and the geometry when it has one.
+func (s *Server) handleSaveMasterMapFeature(w http.ResponseWriter, r *http.Request) error {
+	masterMap, attrKey, key, err := featureKeyVars(r)
+	if err != nil {
+		return err
+	}
+	var feature geojson.Feature
+	if err := json.NewDecoder(r.Body).Decode(&feature); err != nil {
+		return fmt.Errorf("Invalid GeoJSON feature. %s", err.Error())
+	}
+	status := http.StatusOK
+	if r.Method == "POST" {
+		err = s.store.InsertMasterMapFeature(masterMap, attrKey, key, &feature)
+		status = http.StatusCreated
+	} else {
+		err = s.store.UpdateMasterMapFeature(masterMap, attrKey, key, &feature)
+		// the key itself may have been changed
+		if v, ok := feature.Properties[attrKey]; ok && v != nil {
+			key = storage.KeyString(v)
+		}
+	}
+	if errors.Is(err, storage.ErrFeatureNotFound) {
+		return WriteJson(w, http.StatusNotFound, ApiError{Error: fmt.Sprintf("Feature with %s = %s is not found in %s.", attrKey, key, masterMap)})
+	}
+	if err != nil {
+		return err
+	}
+	return s.writeFeature(w, status, masterMap, attrKey, key, &types.FeatureQuery{Filters: map[string]string{}})
+}
+
+func (s *Server) handleDeleteMasterMapFeature(w http.ResponseWriter, r *http.Request) error {
+	masterMap, attrKey, key, err := featureKeyVars(r)
+	if err != nil {
+		return err
+	}
+	err = s.store.DeleteMasterMapFeature(masterMap, attrKey, key)
+	if errors.Is(err, storage.ErrFeatureNotFound) {
+		return WriteJson(w, http.StatusNotFound, ApiError{Error: fmt.Sprintf("Feature with %s = %s is not found in %s.", attrKey, key, masterMap)})
+	}
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, ApiSuccess{Message: "Delete successfully"})
+}
+
+// featureKeyVars reads the master map and key of the path and the attr_key parameter.
+func featureKeyVars(r *http.Request) (string, string, string, error) {
+	vars := mux.Vars(r)
+	masterMap, key := vars["name"], vars["key"]
+	attrKey := r.URL.Query().Get("attr_key")
+	if !util.AllNotNil(masterMap, key) {
+		return "", "", "", fmt.Errorf("API parameter is not complete.")
+	}
+	if !util.AllNotNil(attrKey) {
+		return "", "", "", fmt.Errorf("attr_key, The key attribute is needed in the request.")
+	}
+	return masterMap, attrKey, key, nil
+}
+
+// writeFeature answers with the single feature having the key.
+func (s *Server) writeFeature(w http.ResponseWriter, status int, masterMap, attrKey, key string, q *types.FeatureQuery) error {
+	schema, err := s.store.GetMasterMapSchema(masterMap)
+	if err != nil {
+		return err
+	}
+	q.Filters[attrKey] = key
+	// two rows are enough to tell a unique key from an ambiguous one
+	q.Limit, q.Offset = 2, 0
+	features, matched, err := s.store.GetMasterMapFeatures(schema, q)
+	if err != nil {
+		return err
+	}
+	switch {
+	case matched == 0:
+		return WriteJson(w, http.StatusNotFound, ApiError{Error: fmt.Sprintf("Feature with %s = %s is not found in %s.", attrKey, key, masterMap)})
+	case matched > 1:
+		return fmt.Errorf("%v features have %s = %s in %s, the key is not unique.", matched, attrKey, key, masterMap)
+	}
+	return WriteJson(w, status, features[0])
+}
+
+// newFeatureQuery reads the bbox, srid and pagination parameters.
+func newFeatureQuery(r *http.Request) (*types.FeatureQuery, error) {
+	params := r.URL.Query()
+	q := &types.FeatureQuery{Filters: map[string]string{}, Limit: defaultFeatureLimit}
+	var err error
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 || q.Limit > maxFeatureLimit {
+			return nil, fmt.Errorf("limit, Value must be between 1 and %v.", maxFeatureLimit)
+		}
+	}
+	if v := params.Get("offset"); v != "" {
+		if q.Offset, err = strconv.Atoi(v); err != nil || q.Offset < 0 {
+			return nil, fmt.Errorf("offset, Value must be a non negative integer.")
+		}
+	}
+	if v := params.Get("srid"); v != "" {
+		if q.Srid, err = strconv.Atoi(v); err != nil || q.Srid <= 0 {
+			return nil, fmt.Errorf("srid, Value must be a positive integer.")
+		}
+	}
+	if v := params.Get("bbox_srid"); v != "" {
+		if q.BBoxSrid, err = strconv.Atoi(v); err != nil || q.BBoxSrid <= 0 {
+			return nil, fmt.Errorf("bbox_srid, Value must be a positive integer.")
+		}
+	}
+	if v := params.Get("bbox"); v != "" {
+		parts := strings.Split(v, ",")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("bbox, Value must be minx,miny,maxx,maxy.")
+		}
+		var c [4]float64
+		for i, p := range parts {
+			if c[i], err = strconv.ParseFloat(strings.TrimSpace(p), 64); err != nil {
+				return nil, fmt.Errorf("bbox, Value must be minx,miny,maxx,maxy.")
+			}
+		}
+		if c[0] > c[2] || c[1] > c[3] {
+			return nil, fmt.Errorf("bbox, minx and miny cannot be larger than maxx and maxy.")
+		}
+		q.BBox = &types.Extent{MinX: c[0], MinY: c[1], MaxX: c[2], MaxY: c[3]}
+	}
+	return q, nil
+}
+
+func newFeatureCollection(schema *types.MasterMapSchema, q *types.FeatureQuery, features []*geojson.Feature, matched int) *FeatureCollectionResponse {
+	srid := schema.Srid
+	if q.Srid != 0 {
+		srid = q.Srid
+	}
+	return &FeatureCollectionResponse{
+		Type:           "FeatureCollection",
+		Name:           schema.Name,
+		CRS:            util.GeoJSONCRS(srid),
+		NumberMatched:  matched,
+		NumberReturned: len(features),
+		Features:       features,
+	}
+}
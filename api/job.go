@@ -0,0 +1,232 @@
+package api
+
+import (
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/nahrx/geomatis-api/types"
+	"github.com/nahrx/geomatis-api/util"
+)
+
+// jobDir holds the uploaded rasters of a job until the job is finished.
+const jobDir = "jobs"
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+	switch r.Method {
+	case "GET":
+		return s.handleGetJobs(w, r)
+	case "OPTIONS":
+		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
+	}
+	return fmt.Errorf("Method not allowed")
+}
+func (s *Server) handleGetJobs(w http.ResponseWriter, r *http.Request) error {
+	data, err := s.store.GetJobs()
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, data)
+}
+func (s *Server) handleJobById(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+	switch r.Method {
+	case "GET":
+		return s.handleGetJobById(w, r)
+	case "OPTIONS":
+		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
+	}
+	return fmt.Errorf("Method not allowed")
+}
+func (s *Server) handleGetJobById(w http.ResponseWriter, r *http.Request) error {
+	id := mux.Vars(r)["id"]
+	if !util.AllNotNil(id) {
+		return fmt.Errorf("API parameter is not complete.")
+	}
+	data, err := s.store.GetJob(id)
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, data)
+}
+
+// NewJob stages the uploaded rasters under jobDir and stores the queued job.
+func (s *Server) NewJob(g *types.GeoreferenceSettings, params map[string]string, rasters []*multipart.FileHeader) (*types.Job, error) {
+	id := uuid.NewString()
+	stageDir := filepath.Join(jobDir, id)
+	if err := os.MkdirAll(stageDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("Failed to create directory %s. error : %s.", stageDir, err.Error())
+	}
+	var files []types.Result
+	for i, fileHeader := range rasters {
+		filename := filepath.Base(fileHeader.Filename)
+		if err := util.SaveFile(filepath.Join(stageDir, stagedFileName(i, filename)), fileHeader); err != nil {
+			os.RemoveAll(stageDir)
+			return nil, fmt.Errorf("Failed to save file %s. error : %s.", filename, err.Error())
+		}
+		files = append(files, types.Result{
+			Filename: filename,
+			Status:   types.JobQueued,
+		})
+	}
+	job := &types.Job{
+		Id:      id,
+		Status:  types.JobQueued,
+		DirPath: g.TargetDir,
+		Total:   len(files),
+		Files:   files,
+		Params:  params,
+	}
+	if err := s.store.CreateJob(job); err != nil {
+		os.RemoveAll(stageDir)
+		return nil, err
+	}
+	return job, nil
+}
+
+// stagedFileName prefixes a staged raster with its index, so rasters uploaded with
+// the same name do not overwrite each other.
+func stagedFileName(index int, filename string) string {
+	return fmt.Sprintf("%d-%s", index, filename)
+}
+
+// jobQueue hands the job ids to the single runner in the order they were queued.
+// Pushing never blocks, so a request is not held while another job runs.
+type jobQueue struct {
+	mu    sync.Mutex
+	ids   []string
+	ready chan struct{}
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{ready: make(chan struct{}, 1)}
+}
+
+func (q *jobQueue) push(id string) {
+	q.mu.Lock()
+	q.ids = append(q.ids, id)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop waits for the oldest queued id.
+func (q *jobQueue) pop() string {
+	for {
+		q.mu.Lock()
+		if len(q.ids) > 0 {
+			id := q.ids[0]
+			q.ids = q.ids[1:]
+			q.mu.Unlock()
+			return id
+		}
+		q.mu.Unlock()
+		<-q.ready
+	}
+}
+
+func (s *Server) enqueueJob(id string) {
+	s.jobs.push(id)
+}
+
+// resumeJobs queues the jobs that were not finished when the server stopped.
+func (s *Server) resumeJobs() error {
+	jobs, err := s.store.GetJobs()
+	if err != nil {
+		return err
+	}
+	for i := len(jobs) - 1; i >= 0; i-- {
+		if jobs[i].Status == types.JobQueued || jobs[i].Status == types.JobRunning {
+			fmt.Println("resume job : ", jobs[i].Id)
+			s.enqueueJob(jobs[i].Id)
+		}
+	}
+	return nil
+}
+
+func (s *Server) runJobs() {
+	for {
+		s.runJob(s.jobs.pop())
+	}
+}
+
+func (s *Server) runJob(id string) {
+	job, err := s.store.GetJob(id)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// a resumed job starts over, writing the same world files again is harmless
+	job.Status = types.JobRunning
+	job.Success, job.Fail, job.Err, job.Reports = 0, 0, "", nil
+	for i := range job.Files {
+		job.Files[i] = types.Result{
+			Filename: job.Files[i].Filename,
+			Status:   types.JobQueued,
+		}
+	}
+	if err := s.store.RestartJob(job); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	finish := func(status, errMsg string) {
+		job.Status = status
+		job.Err = errMsg
+		if err := s.store.UpdateJob(job); err != nil {
+			fmt.Println(err.Error())
+		}
+	}
+	g, err := s.NewGeoreferenceSettings(job.Params)
+	if err != nil {
+		finish(types.JobFailed, err.Error())
+		return
+	}
+	if err := os.MkdirAll(g.TargetDir, os.ModePerm); err != nil {
+		finish(types.JobFailed, fmt.Sprintf("Failed to create directory %s. error : %s.", g.TargetDir, err.Error()))
+		return
+	}
+
+	stageDir := filepath.Join(jobDir, job.Id)
+	rasters := make([]types.RasterFile, len(job.Files))
+	for i, f := range job.Files {
+		rasters[i] = types.RasterFile{
+			Filename: f.Filename,
+			Path:     filepath.Join(stageDir, stagedFileName(i, f.Filename)),
+		}
+	}
+	s.GeoreferenceRasterFiles(&types.GeoreferenceRequest{Raster: rasters, Settings: g}, func(i int, r types.Result) {
+		job.Files[i] = r
+		if r.Error != nil {
+			job.Fail++
+		} else {
+			job.Success++
+		}
+		if err := s.store.UpdateJobFile(job, i); err != nil {
+			fmt.Println(err.Error())
+		}
+	})
+
+	// report is written next to the outputs so it can be downloaded through /exports
+	var errMsg = ""
+	reportName := filepath.Join(g.TargetDir, "georeference-report-"+job.Id)
+	for _, report := range []string{reportName + ".json", reportName + ".csv"} {
+		if err := util.WriteGeoreferenceReport(report, job.Files); err != nil {
+			errMsg = fmt.Sprintf("Failed to write report %s. error : %s.", report, err.Error())
+			break
+		}
+		job.Reports = append(job.Reports, report)
+	}
+	finish(types.JobDone, errMsg)
+	if err := os.RemoveAll(stageDir); err != nil {
+		fmt.Println(err.Error())
+	}
+}
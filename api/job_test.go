@@ -0,0 +1,42 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestJobQueueOrder(t *testing.T) {
+	q := newJobQueue()
+	var want []string
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("job-%d", i)
+		q.push(id)
+		want = append(want, id)
+	}
+	for _, id := range want {
+		if got := q.pop(); got != id {
+			t.Fatalf("pop = %s, want %s", got, id)
+		}
+	}
+}
+
+func TestJobQueueWaits(t *testing.T) {
+	q := newJobQueue()
+	got := make(chan string)
+	go func() { got <- q.pop() }()
+	select {
+	case id := <-got:
+		t.Fatalf("pop returned %s from an empty queue", id)
+	case <-time.After(20 * time.Millisecond):
+	}
+	q.push("a")
+	select {
+	case id := <-got:
+		if id != "a" {
+			t.Errorf("pop = %s, want a", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pop did not wake up after a push")
+	}
+}
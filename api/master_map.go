@@ -0,0 +1,269 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/nahrx/geomatis-api/types"
+	"github.com/nahrx/geomatis-api/util"
+)
+
+// defaultMasterMapMaxSize applies when MASTER_MAP_MAX_SIZE is not set.
+const defaultMasterMapMaxSize int64 = 1 << 30
+
+// finished imports are listed by GET /imports for this long
+const importRetention = 24 * time.Hour
+
+// masterMapMaxSize is the upload limit of POST /master-maps in bytes.
+func masterMapMaxSize() (int64, error) {
+	value := os.Getenv("MASTER_MAP_MAX_SIZE")
+	if value == "" {
+		return defaultMasterMapMaxSize, nil
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("MASTER_MAP_MAX_SIZE must be a positive number of bytes")
+	}
+	return size, nil
+}
+
+func uploadError(err error, maxFileSize int64) error {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return fmt.Errorf("The uploaded file cannot be larger than %v", maxFileSize)
+	}
+	return fmt.Errorf("error file content processing. error : %s", err.Error())
+}
+
+// spoolUpload copies the uploaded layer to a temporary file, counting the bytes
+// for the import progress.
+func (s *Server) spoolUpload(part io.Reader, ext string, id string) (string, error) {
+	file, err := os.CreateTemp("", "master-map-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	counter := &countingReader{r: part, onRead: func(n int64) {
+		s.updateImport(id, func(p *types.ImportProgress) { p.Bytes = n })
+	}}
+	_, err = io.Copy(file, counter)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+type countingReader struct {
+	r      io.Reader
+	n      int64
+	onRead func(int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	c.onRead(c.n)
+	return n, err
+}
+
+// readShapefileUpload reads the uploaded zip, every file in it is held to the
+// upload limit once decompressed.
+func readShapefileUpload(filePath, layer string, maxFileSize int64) (*util.Layer, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return util.ReadShapefileZip(file, info.Size(), layer, maxFileSize)
+}
+
+// startImport registers a new import and drops the old finished ones. id is the
+// import_id sent by the client to follow the import on GET /imports while it
+// runs, a uuid is generated when it is empty.
+func (s *Server) startImport(id string) (string, error) {
+	if len(id) > 64 {
+		return "", fmt.Errorf("import_id, Import id cannot be longer than 64 characters.")
+	}
+	s.importsMu.Lock()
+	defer s.importsMu.Unlock()
+	for key, p := range s.imports {
+		if (p.Stage == types.ImportDone || p.Stage == types.ImportFailed) && time.Since(p.UpdatedAt) > importRetention {
+			delete(s.imports, key)
+		}
+	}
+	if id == "" {
+		id = uuid.NewString()
+	} else if _, ok := s.imports[id]; ok {
+		return "", fmt.Errorf("import_id, Import %s already exists.", id)
+	}
+	now := time.Now()
+	s.imports[id] = &types.ImportProgress{
+		Id:        id,
+		Stage:     types.ImportUpload,
+		StartedAt: now,
+		UpdatedAt: now,
+	}
+	return id, nil
+}
+
+func (s *Server) updateImport(id string, update func(*types.ImportProgress)) {
+	s.importsMu.Lock()
+	defer s.importsMu.Unlock()
+	if p, ok := s.imports[id]; ok {
+		update(p)
+		p.UpdatedAt = time.Now()
+	}
+}
+
+func (s *Server) handleImports(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+	switch r.Method {
+	case "GET":
+		return s.handleGetImports(w, r)
+	case "OPTIONS":
+		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
+	}
+	return fmt.Errorf("Method not allowed")
+}
+
+// handleGetImports lists the running and recently finished master map imports,
+// newest first.
+func (s *Server) handleGetImports(w http.ResponseWriter, r *http.Request) error {
+	s.importsMu.Lock()
+	data := make([]types.ImportProgress, 0, len(s.imports))
+	for _, p := range s.imports {
+		data = append(data, *p)
+	}
+	s.importsMu.Unlock()
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].StartedAt.After(data[j].StartedAt)
+	})
+	return WriteJson(w, http.StatusOK, data)
+}
+
+func (s *Server) handleMasterMapExport(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+	switch r.Method {
+	case "GET":
+		return s.handleExportMasterMap(w, r)
+	case "OPTIONS":
+		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
+	}
+	return fmt.Errorf("Method not allowed")
+}
+
+// handleExportMasterMap streams a stored master map back as GeoJSON or GeoPackage
+// with its attributes and CRS.
+func (s *Server) handleExportMasterMap(w http.ResponseWriter, r *http.Request) error {
+	masterMap := mux.Vars(r)["name"]
+	if !util.AllNotNil(masterMap) {
+		return fmt.Errorf("API parameter is not complete.")
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "geojson"
+	}
+	if format != "geojson" && format != "gpkg" {
+		return fmt.Errorf("format, Export format must be geojson or gpkg.")
+	}
+	schema, err := s.store.GetMasterMapSchema(masterMap)
+	if err != nil {
+		return err
+	}
+
+	if format == "geojson" {
+		AddCorsHeader(w)
+		w.Header().Set("Content-Type", "application/geo+json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.geojson\"", masterMap))
+		gw, err := util.NewGeoJSONWriter(w, masterMap, schema.Srid)
+		if err != nil {
+			return err
+		}
+		// the status is already sent, a failure can only cut the stream
+		if err := s.store.EachMasterMapFeature(schema, gw.Write); err != nil {
+			fmt.Println("export", masterMap, ":", err.Error())
+			return nil
+		}
+		return gw.Close()
+	}
+
+	wkt, err := s.spatialReference(schema.Srid)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp("", "export-*.gpkg")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	gw, err := util.NewGeoPackageWriter(file.Name(), schema, wkt)
+	if err != nil {
+		return fmt.Errorf("Error NewGeoPackageWriter : %s", err.Error())
+	}
+	err = s.store.EachMasterMapFeature(schema, gw.Write)
+	if closeErr := gw.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing geopackage : %s", err.Error())
+	}
+
+	gpkg, err := os.Open(file.Name())
+	if err != nil {
+		return err
+	}
+	defer gpkg.Close()
+	AddCorsHeader(w)
+	w.Header().Set("Content-Type", "application/geopackage+sqlite3")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.gpkg\"", masterMap))
+	_, err = io.Copy(w, gpkg)
+	if err != nil {
+		fmt.Println("export", masterMap, ":", err.Error())
+	}
+	return nil
+}
+
+func (s *Server) handleMasterMapValidate(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+	switch r.Method {
+	case "GET":
+		return s.handleGetMasterMapValidate(w, r)
+	case "OPTIONS":
+		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
+	}
+	return fmt.Errorf("Method not allowed")
+}
+
+// handleGetMasterMapValidate reports duplicate and null keys, invalid and empty
+// geometries and multipart features, e.g. /master-maps/sls/validate?key=idsls
+func (s *Server) handleGetMasterMapValidate(w http.ResponseWriter, r *http.Request) error {
+	masterMap := mux.Vars(r)["name"]
+	key := r.URL.Query().Get("key")
+	if !util.AllNotNil(masterMap) {
+		return fmt.Errorf("API parameter is not complete.")
+	}
+	if !util.AllNotNil(key) {
+		return fmt.Errorf("key, The key attribute is needed in the request.")
+	}
+	data, err := s.store.ValidateMasterMap(masterMap, key)
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, data)
+}
@@ -1,820 +1,1237 @@
-package api
-
-import (
-	"archive/zip"
-	"encoding/json"
-	"fmt"
-	"io"
-	"mime/multipart"
-	"net/http"
-	"os"
-	"path"
-	"path/filepath"
-	"regexp"
-	"strconv"
-	"strings"
-
-	"github.com/google/uuid"
-	"github.com/gorilla/mux"
-	"github.com/nahrx/geomatis-api/storage"
-	"github.com/nahrx/geomatis-api/types"
-	"github.com/nahrx/geomatis-api/util"
-)
-
-type Server struct {
-	listenAddr string
-	store      storage.Storage
-}
-type ApiError struct {
-	Error string `json:"error"`
-}
-type ApiSuccess struct {
-	Message string `json:"message"`
-}
-type apiFunc func(http.ResponseWriter, *http.Request) error
-
-func makeHttpHandleFunc(f apiFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if err := f(w, r); err != nil {
-			WriteJson(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-		}
-	}
-}
-func AddCorsHeader(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "*")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
-}
-func WriteJson(w http.ResponseWriter, status int, v any) error {
-	w.Header().Set("Content-Type", "application/json")
-	AddCorsHeader(w)
-	w.WriteHeader(status)
-	fmt.Println(v)
-	return json.NewEncoder(w).Encode(v)
-}
-
-func ReqVars(r *http.Request) (map[string]interface{}, error) {
-	var result map[string]interface{}
-	err := json.NewDecoder(r.Body).Decode(&result)
-	if err != nil {
-		return nil, err
-	}
-	return result, nil
-}
-
-func NewServer(listenAddr string, store storage.Storage) *Server {
-	return &Server{
-		listenAddr: listenAddr,
-		store:      store,
-	}
-}
-
-func (s *Server) Start() error {
-	r := mux.NewRouter()
-	r.HandleFunc("/master-maps", makeHttpHandleFunc(s.handleMasterMaps))
-	r.HandleFunc("/master-maps/{name}", makeHttpHandleFunc(s.handleMasterMapsByName))
-	r.HandleFunc("/master-maps/{name}/attributes", makeHttpHandleFunc(s.handleMasterMapAttributes))
-	r.HandleFunc("/georeference", makeHttpHandleFunc(s.handleGeoreference))
-	r.HandleFunc("/repos", makeHttpHandleFunc(s.handleRepos))
-	r.HandleFunc("/exports", makeHttpHandleFunc(s.handleExports))
-
-	return http.ListenAndServe(s.listenAddr, r)
-}
-
-func (s *Server) handleRepos(w http.ResponseWriter, r *http.Request) error {
-	fmt.Println(r.Method)
-	switch r.Method {
-	case "POST":
-		return s.handleGetRepos(w, r)
-	case "DELETE":
-		return s.handleDeleteRepos(w, r)
-	case "PUT":
-		return s.handleUpdateRepos(w, r)
-	case "OPTIONS":
-		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
-	}
-	//return WriteJson(w, http.StatusMethodNotAllowed, ApiError{Error: "Method not allowed"})
-	return fmt.Errorf("Method not allowed")
-}
-
-type Dir struct {
-	Name  string `json:"name"`
-	IsDir bool   `json:"is_dir"`
-	Size  int64  `json:"size"`
-}
-
-func (s *Server) handleGetRepos(w http.ResponseWriter, r *http.Request) error {
-	vars, err := ReqVars(r)
-	if err != nil {
-		return fmt.Errorf("error ReqVars : %w", err)
-	}
-	vPath, ok := vars["path"]
-	if !ok {
-		vPath = ""
-	}
-	path := filepath.Join("uploads", vPath.(string))
-
-	pathInfo, err := os.Stat(path)
-	if err != nil {
-		return fmt.Errorf("error os.Stat : %w", err)
-	}
-	if !pathInfo.IsDir() {
-		return fmt.Errorf("%s is not a directory.", path)
-	}
-
-	files, err := os.ReadDir(path)
-	if err != nil {
-		return fmt.Errorf("error os.ReadDir : %w", err)
-	}
-	var list []Dir
-	for _, file := range files {
-		info, _ := file.Info()
-		file := Dir{
-			Name:  file.Name(),
-			IsDir: file.IsDir(),
-			Size:  info.Size(),
-		}
-		list = append(list, file)
-	}
-	return WriteJson(w, http.StatusOK, list)
-}
-func (s *Server) handleDeleteRepos(w http.ResponseWriter, r *http.Request) error {
-	vars, err := ReqVars(r)
-	if err != nil {
-		return err
-	}
-
-	vPath := vars["path"]
-	if !util.AllNotNil(vPath) {
-		return fmt.Errorf("API parameter is not complete.")
-	}
-
-	path := filepath.Join("uploads", vPath.(string))
-	fmt.Println(path)
-	err = os.RemoveAll(path)
-	if err != nil {
-		return err
-	}
-	return WriteJson(w, http.StatusOK, ApiSuccess{Message: "Delete successfully"})
-}
-func (s *Server) handleUpdateRepos(w http.ResponseWriter, r *http.Request) error {
-	//defer r.Body.Close()
-	vars, err := ReqVars(r)
-	if err != nil {
-		return err
-	}
-	vPath := vars["path"]
-	vNewPath := vars["new_path"]
-	vMethod := vars["method"]
-
-	if !util.AllNotNil(vPath, vNewPath, vMethod) {
-		return fmt.Errorf("API parameter is not complete.")
-	}
-	path := filepath.Join("uploads/", vPath.(string))
-	newPath := filepath.Join("uploads/", vNewPath.(string))
-	method := vMethod.(string)
-
-	if method != "rename" {
-		return fmt.Errorf("method for updating repository not allowed")
-	}
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return fmt.Errorf("path does not exist")
-	}
-	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
-		return fmt.Errorf("newPath already exist")
-	}
-	if path == newPath {
-		return fmt.Errorf("path is not changed")
-	}
-	err = os.Rename(path, newPath)
-	if err != nil {
-		return err
-	}
-	return WriteJson(w, http.StatusOK, ApiSuccess{Message: "rename successfully"})
-}
-func (s *Server) handleExports(w http.ResponseWriter, r *http.Request) error {
-	fmt.Println(r.Method)
-	switch r.Method {
-	case "POST":
-		return s.handleDownload(w, r)
-	}
-	//return WriteJson(w, http.StatusMethodNotAllowed, ApiError{Error: "Method not allowed"})
-	return fmt.Errorf("Method not allowed")
-}
-func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) error {
-	vars, err := ReqVars(r)
-	vPath := vars["path"]
-	if err != nil {
-		return err
-	}
-	if !util.AllNotNil(vPath) {
-		return fmt.Errorf("API parameter is not complete.")
-	}
-	path := filepath.Join("uploads", vPath.(string))
-	fileInfo, err := os.Stat(path)
-	if err != nil {
-		return fmt.Errorf("File or directory not found")
-	}
-
-	AddCorsHeader(w)
-	w.WriteHeader(200)
-	w.Header().Set("Content-Type", "application/octet-stream")
-
-	if fileInfo.IsDir() {
-		// Zip the directory and serve as download
-		zipFileName := fileInfo.Name() + ".zip"
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", zipFileName))
-		zipWriter := zip.NewWriter(w)
-		defer zipWriter.Close()
-
-		err = util.ZipDirectory(path, zipWriter, path)
-		if err != nil {
-			return fmt.Errorf("Error ZipDirectory : %w", err)
-		}
-	} else {
-		// Serve the file directly
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileInfo.Name()))
-
-		file, err := os.Open(path)
-		if err != nil {
-			return fmt.Errorf("Error os.Open : %w", err)
-		}
-		defer file.Close()
-
-		_, err = io.Copy(w, file)
-		if err != nil {
-			return fmt.Errorf("Error os.Copy : %w", err)
-		}
-	}
-
-	return nil
-}
-func (s *Server) handleGeoreference(w http.ResponseWriter, r *http.Request) error {
-	fmt.Println(r.Method)
-	switch r.Method {
-	case "POST":
-		return s.handleCreateWorldFiles(w, r)
-	}
-	//return WriteJson(w, http.StatusMethodNotAllowed, ApiError{Error: "Method not allowed"})
-	return fmt.Errorf("Method not allowed")
-}
-func (s *Server) handleCreateWorldFiles(w http.ResponseWriter, r *http.Request) error {
-	var maxRequestBodySize int64 = 300 << 20 // 10*2^20 = 10 MB (pembulatan)
-	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
-	// _, err := r.MultipartReader()
-	// if err != nil {
-	// 	return fmt.Errorf("Error MultipartReader : ,%w", err)
-	// }
-	defer r.Body.Close()
-
-	geoRequest, err := s.NewGeoreferenceRequest(r)
-	if err != nil {
-		return err
-	}
-	geoSettings := geoRequest.Settings
-	//Create Directory
-	uuidPath := uuid.NewString()
-	//dirPath := filepath.Join(basePath, uuidPath)
-	fmt.Println(uuidPath)
-	dirPath := filepath.Join(geoSettings.TargetDir)
-	if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
-		return fmt.Errorf("Failed to create directory %s. error : %s.", dirPath, err.Error())
-	}
-	success, fail, err := s.GeoreferenceRasterFiles(geoRequest)
-
-	var errMsg = ""
-	if err != nil {
-		errMsg = err.Error()
-	}
-	response := Georeference_response{
-		Dir_path: geoSettings.TargetDir,
-		Success:  success,
-		Fail:     fail,
-		Err:      errMsg,
-	}
-	return WriteJson(w, http.StatusOK, response)
-}
-
-type Georeference_response struct {
-	Dir_path string `json:"dir_path"`
-	Success  int    `json:"success"`
-	Fail     int    `json:"fail"`
-	Err      string `json:"error"`
-}
-
-func GetWorldFileExtlist() map[string]string {
-	return map[string]string{
-		".jpg":  ".jgw",
-		".jpeg": ".jgw",
-		".png":  ".pgw",
-	}
-}
-func NewRasterKeySettings(category, prefixNumChar, suffixNumChar, regex string) (*types.RasterKeySettings, error) {
-	var err error
-	var rasterKey types.RasterKeySettings
-	rasterKey.Type = category
-	switch category {
-	case "all":
-		break
-	case "prefix":
-		rasterKey.NumChar, err = strconv.Atoi(prefixNumChar)
-		if err != nil {
-			return nil, fmt.Errorf("raster_key_prefix_num_char, Number of prefix raster key characters is not valid. error : %s.", err.Error())
-		}
-		if rasterKey.NumChar <= 0 {
-			return nil, fmt.Errorf("raster_key_prefix_num_char, Number of prefix raster key characters is not valid.")
-		}
-		break
-	case "suffix":
-		rasterKey.NumChar, err = strconv.Atoi(suffixNumChar)
-		if err != nil {
-			return nil, fmt.Errorf("raster_key_suffix_num_char, Number of suffix Raster key characters is not valid. error : %s.", err.Error())
-		}
-		if rasterKey.NumChar <= 0 {
-			return nil, fmt.Errorf("raster_key_prefix_num_char, Number of prefix raster key characters is not valid.")
-		}
-		break
-	case "regex":
-		rasterKey.Regex, err = regexp.Compile(regex)
-		if err != nil {
-			return nil, fmt.Errorf("Raster key regex is not valid. error : %s.", err.Error())
-		}
-		break
-	default:
-		return nil, fmt.Errorf("Type of raster key is not valid. Only all, prefix, suffix, or regex allowed.")
-	}
-	return &rasterKey, nil
-}
-func GetRasterKey(filename string, rasterKeySettings *types.RasterKeySettings) (string, error) {
-	filename = util.FileNameWithoutExtension(filename)
-	if len(filename) < rasterKeySettings.NumChar {
-		return "", fmt.Errorf("Length of string is not enough (less than rasterKeySettings.NumChar)")
-	}
-	switch rasterKeySettings.Type {
-	case "all":
-		return filename, nil
-	case "prefix":
-		return filename[:rasterKeySettings.NumChar], nil
-	case "suffix":
-		return filename[len(filename)-rasterKeySettings.NumChar:], nil
-	case "regex":
-		return rasterKeySettings.Regex.FindString(filename), nil
-	}
-	return "", fmt.Errorf("Type of raster key is not valid. Only all, prefix, suffix, or regex allowed.")
-}
-func NewRasterFeatureSettings(xPosition, yPosition, margin string) (*types.RasterFeatureSettings, error) {
-	var marginfloat64 float64
-	marginfloat64, err := strconv.ParseFloat(margin, 64)
-	if err != nil {
-		return nil, fmt.Errorf("Feature margin is not valid. error : %s", err)
-	}
-	return &types.RasterFeatureSettings{
-		XPosition: xPosition,
-		YPosition: yPosition,
-		Margin:    marginfloat64,
-	}, nil
-}
-
-func (s *Server) NewGeoreferenceRequest(r *http.Request) (*types.GeoreferenceRequest, error) {
-	err := r.ParseMultipartForm(300 << 20) // grab the multipart form
-	if err != nil {
-		return nil, fmt.Errorf("Error ParseMultipartForm : %w", err)
-	}
-
-	multipartForm := r.MultipartForm
-
-	masterMap := r.FormValue("master_map")
-	attrKey := r.FormValue("attr_key")
-	rasterKeyType := r.FormValue("raster_key_type")
-	rasterKeyPrefixNumChar := r.FormValue("raster_key_prefix_num_char")
-	rasterKeySuffixNumChar := r.FormValue("raster_key_suffix_num_char")
-	rasterKeyRegex := r.FormValue("raster_key_regex")
-	targetDir := r.FormValue("target_dir")
-	separateDir := r.FormValue("separate_dir")
-	featureXPosition := r.FormValue("feature_x_position")
-	featureYPosition := r.FormValue("feature_y_position")
-	featureMargin := r.FormValue("feature_margin")
-
-	fmt.Println(masterMap)
-	fmt.Println(attrKey)
-	fmt.Println(rasterKeyType)
-	fmt.Println(targetDir)
-	fmt.Println(separateDir)
-
-	if !util.AllNotNil(multipartForm) {
-		return nil, fmt.Errorf("API parameter is not complete.")
-	}
-
-	rasters := multipartForm.File["rasters"]
-
-	if len(rasters) == 0 {
-		return nil, fmt.Errorf("missing rasters file")
-	}
-	if masterMap == "" {
-		return nil, fmt.Errorf("missing master_map parameter")
-	}
-	if attrKey == "" {
-		return nil, fmt.Errorf("missing attr_key parameter")
-	}
-	if rasterKeyType == "" {
-		return nil, fmt.Errorf("missing raster_key_type parameter")
-	}
-
-	masterMapExist, err := s.store.MasterMapExist(masterMap)
-	if err != nil {
-		return nil, fmt.Errorf("Error when calling MasterMapExist. Error :  %s", err.Error())
-	}
-	if !masterMapExist {
-		return nil, fmt.Errorf("%s is not found in the database. Error :  %s", masterMap, err.Error())
-	}
-
-	attrKeyExist, err := s.store.MasterMapAttributeExist(masterMap, attrKey)
-	if err != nil {
-		return nil, fmt.Errorf("Error when calling MasterMapExist. Error :  %s", err.Error())
-	}
-	if !attrKeyExist {
-		return nil, fmt.Errorf("%s is not found in the database. Error :  %s", masterMap, err.Error())
-	}
-	rasterKey, err := NewRasterKeySettings(rasterKeyType, rasterKeyPrefixNumChar, rasterKeySuffixNumChar, rasterKeyRegex)
-	if err != nil {
-		return nil, fmt.Errorf("Error when calling NewRasterKeySettings. Error :  %s", err.Error())
-	}
-
-	var separateDirArray []string
-	if separateDir == "" {
-		separateDir = "[]"
-	}
-
-	if err := json.Unmarshal([]byte(separateDir), &separateDirArray); err != nil {
-		return nil, fmt.Errorf("Error Unmarshal separateDir Json. Error : %s", err.Error())
-	}
-
-	regexValidDir, err := regexp.Compile("([a-zA-Z0-9 \\/])\\w+")
-	if err != nil {
-		return nil, fmt.Errorf("Error regexp.Compile. Error : %s", err.Error())
-	}
-	targetDir = filepath.Join("uploads", targetDir)
-	if !regexValidDir.MatchString(targetDir) {
-		return nil, fmt.Errorf("Target directory name must be valid.")
-	}
-
-	rasterFeature, err := NewRasterFeatureSettings(featureXPosition, featureYPosition, featureMargin)
-	if err != nil {
-		return nil, fmt.Errorf("Error when calling NewRasterFeatureSettings. Error : %s", err.Error())
-	}
-	return &types.GeoreferenceRequest{
-		Raster: rasters,
-		Settings: &types.GeoreferenceSettings{
-			MasterMap:             masterMap,
-			AttrKey:               attrKey,
-			RasterKeySettings:     rasterKey,
-			TargetDir:             targetDir,
-			SeparateDirAttrs:      separateDirArray,
-			RasterFeatureSettings: rasterFeature,
-		},
-	}, nil
-}
-
-func (s *Server) GeoreferenceRasterFiles_backup(g *types.GeoreferenceRequest) error {
-	var e error = nil
-	gSettings := g.Settings
-	for _, fileHeader := range g.Raster {
-		//Get image dimension
-		file1, err := fileHeader.Open()
-		if err != nil {
-			err = fmt.Errorf("Error while converting multipart FileHeader to File. error : %s.", err.Error())
-			e = fmt.Errorf("%w\n %w", e, err)
-			break
-		}
-		file2, err := fileHeader.Open()
-		if err != nil {
-			err = fmt.Errorf("Error while converting multipart FileHeader to File. error : %s.", err.Error())
-			e = fmt.Errorf("%w\n %w", e, err)
-			break
-		}
-
-		// img, err := util.GetOrientedImageDimensions(file)
-		// if err != nil {
-		// 	err = fmt.Errorf("Error GetImageDimensions : %s.", err.Error())
-		// 	e = fmt.Errorf("%w\n %w", e, err)
-		// 	break
-		// }
-		imgDim, err := util.GetOrientedImageDimensions(file1, file2)
-
-		if err != nil {
-			err = fmt.Errorf("Error GetOrientationTag : %w", err)
-			e = fmt.Errorf("%w\n %w", e, err)
-			break
-		}
-		//Get raster key
-		rasterKey, err := GetRasterKey(fileHeader.Filename, gSettings.RasterKeySettings)
-		if err != nil {
-			err = fmt.Errorf("Error GetRasterKey: %s.", err.Error())
-			e = fmt.Errorf("%w\n %w", e, err)
-			break
-		}
-
-		//Get separateDir attributes and save file
-		separateDirName, err := s.store.GetAttributesValue(gSettings.MasterMap, gSettings.AttrKey, rasterKey, gSettings.SeparateDirAttrs)
-		if err != nil {
-			err = fmt.Errorf("Error GetAttributesValue : %s.", err.Error())
-			e = fmt.Errorf("%w\n %w", e, err)
-			break
-		}
-
-		dir := strings.Join(separateDirName, "/")
-		targetDir := filepath.Join(gSettings.TargetDir, dir)
-		filePath := filepath.Join(targetDir, fileHeader.Filename)
-
-		if err := os.MkdirAll(targetDir, os.ModePerm); err != nil {
-			err = fmt.Errorf("Failed to create directory %s. error : %s.", targetDir, err.Error())
-			e = fmt.Errorf("%w\n %w", e, err)
-			break
-		}
-
-		err = util.SaveFile(filePath, fileHeader)
-		if err != nil {
-			err = fmt.Errorf("Failed to save file. error : %s.", err.Error())
-			e = fmt.Errorf("%w\n %w", e, err)
-			break
-		}
-		//Get polygon extent, raster feature point from image
-
-		polygonExtent, err := s.store.GetExtent(gSettings.MasterMap, rasterKey)
-		if err != nil {
-			err = fmt.Errorf("Error GetExtent : %s.", err.Error())
-			e = fmt.Errorf("%w\n %w", e, err)
-			break
-		}
-
-		featurePoints, err := util.GetRasterFeaturePoints(filePath)
-		if err != nil {
-			err = fmt.Errorf("Error GetRasterFeaturePoints : %s.", err.Error())
-			e = fmt.Errorf("%w\n %w", e, err)
-			break
-		}
-
-		//Calculate Georeference Parameter and save world file
-		parameter := util.CalculateGeoreferenceParameters(imgDim, featurePoints, *polygonExtent, gSettings.RasterFeatureSettings.Margin)
-		worldFileExt := GetWorldFileExtlist()[strings.ToLower(path.Ext(fileHeader.Filename))]
-		fmt.Println("worldFileExt : ", worldFileExt)
-		worldFileName := fmt.Sprintf("%s%s", util.FileNameWithoutExtension(filePath), worldFileExt)
-		err = util.WriteWorldFileParametersToFile(worldFileName, *parameter)
-		if err != nil {
-			err = fmt.Errorf("Error while creating worldfile. error : %s.", err.Error())
-			e = fmt.Errorf("%w\n %w", e, err)
-			break
-		}
-	}
-	return e
-}
-func (s *Server) worker(id int, gRaster <-chan *multipart.FileHeader, results chan<- types.Result, g *types.GeoreferenceSettings) {
-	fmt.Println("worker : ", id)
-	for fileHeader := range gRaster {
-		result := types.Result{
-			Id:    fileHeader.Filename,
-			Error: nil,
-		}
-		//Get image dimension
-		file1, err := fileHeader.Open()
-		if err != nil {
-			result.Error = fmt.Errorf("Error while converting multipart FileHeader to File. error : %s.", err.Error())
-			results <- result
-			continue
-		}
-		file2, err := fileHeader.Open()
-		if err != nil {
-			result.Error = fmt.Errorf("Error while converting multipart FileHeader to File. error : %s.", err.Error())
-			results <- result
-			continue
-		}
-
-		// img, err := util.GetOrientedImageDimensions(file)
-		// if err != nil {
-		// 	err = fmt.Errorf("Error GetImageDimensions : %s.", err.Error())
-		// 	e = fmt.Errorf("%w\n %w", e, err)
-		// 	break
-		// }
-		imgDim, err := util.GetOrientedImageDimensions(file1, file2)
-
-		if err != nil {
-			result.Error = fmt.Errorf("Error GetOrientationTag : %w", err)
-			results <- result
-			continue
-		}
-		//Get raster key
-		rasterKey, err := GetRasterKey(fileHeader.Filename, g.RasterKeySettings)
-		if err != nil {
-			result.Error = fmt.Errorf("Error GetRasterKey: %s.", err.Error())
-			results <- result
-			continue
-		}
-
-		//Get separateDir attributes and save file
-		separateDirName, err := s.store.GetAttributesValue(g.MasterMap, g.AttrKey, rasterKey, g.SeparateDirAttrs)
-		if err != nil {
-			result.Error = fmt.Errorf("Error GetAttributesValue : %s.", err.Error())
-			results <- result
-			continue
-		}
-
-		dir := strings.Join(separateDirName, "/")
-		targetDir := filepath.Join(g.TargetDir, dir)
-		filePath := filepath.Join(targetDir, fileHeader.Filename)
-
-		if err := os.MkdirAll(targetDir, os.ModePerm); err != nil {
-			result.Error = fmt.Errorf("Failed to create directory %s. error : %s.", targetDir, err.Error())
-			results <- result
-			continue
-		}
-
-		err = util.SaveFile(filePath, fileHeader)
-		if err != nil {
-			result.Error = fmt.Errorf("Failed to save file. error : %s.", err.Error())
-			results <- result
-			continue
-		}
-		//Get polygon extent, raster feature point from image
-
-		polygonExtent, err := s.store.GetExtent(g.MasterMap, rasterKey)
-		if err != nil {
-			result.Error = fmt.Errorf("Error GetExtent : %s.", err.Error())
-			results <- result
-			continue
-		}
-
-		featurePoints, err := util.GetRasterFeaturePoints(filePath)
-		if err != nil {
-			result.Error = fmt.Errorf("Error GetRasterFeaturePoints : %s.", err.Error())
-			results <- result
-			continue
-		}
-
-		//Calculate Georeference Parameter and save world file
-		parameter := util.CalculateGeoreferenceParameters(imgDim, featurePoints, *polygonExtent, g.RasterFeatureSettings.Margin)
-		worldFileExt := GetWorldFileExtlist()[strings.ToLower(path.Ext(fileHeader.Filename))]
-		fmt.Println("worldFileExt : ", worldFileExt)
-		worldFileName := fmt.Sprintf("%s%s", util.FileNameWithoutExtension(filePath), worldFileExt)
-		err = util.WriteWorldFileParametersToFile(worldFileName, *parameter)
-		if err != nil {
-			result.Error = fmt.Errorf("Error while creating worldfile. error : %s.", err.Error())
-			results <- result
-			continue
-		}
-		results <- result
-	}
-}
-func (s *Server) GeoreferenceRasterFiles(g *types.GeoreferenceRequest) (int, int, error) {
-	numJobs := len(g.Raster)
-	numWorkers := 20
-	if numJobs < numWorkers {
-		numWorkers = numJobs
-	}
-	fileHeader := make(chan *multipart.FileHeader, numJobs)
-	results := make(chan types.Result, numJobs)
-	for w := 0; w < numWorkers; w++ {
-		go s.worker(w, fileHeader, results, g.Settings)
-	}
-
-	for j := 0; j < numJobs; j++ {
-		fileHeader <- g.Raster[j]
-	}
-	close(fileHeader)
-
-	var e error = nil
-	success, fail := 0, 0
-	for a := 0; a < numJobs; a++ {
-		r := <-results
-		if r.Error != nil {
-			fail++
-			errMsg := fmt.Sprintf("error file %s : %s.", r.Id, r.Error.Error())
-			if e == nil {
-				e = fmt.Errorf(errMsg)
-				continue
-			}
-			e = fmt.Errorf("%s\n %s", e.Error(), errMsg)
-		}
-	}
-	success = numJobs - fail
-	return success, fail, e
-}
-func (s *Server) handleMasterMaps(w http.ResponseWriter, r *http.Request) error {
-	fmt.Println(r.Method)
-	switch r.Method {
-	case "GET":
-		return s.handleGetMasterMaps(w, r)
-	case "POST":
-		return s.handleCreateMasterMaps(w, r)
-	case "OPTIONS":
-		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
-		//default:
-		//http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
-	//return WriteJson(w, http.StatusMethodNotAllowed, ApiError{Error: "Method not allowed"})
-	return fmt.Errorf("Method not allowed")
-}
-func (s *Server) handleGetMasterMaps(w http.ResponseWriter, r *http.Request) error {
-	data, err := s.store.GetMasterMaps()
-	if err != nil {
-		return err
-	}
-	return WriteJson(w, http.StatusOK, data)
-}
-func (s *Server) handleCreateMasterMaps(w http.ResponseWriter, r *http.Request) error {
-	name := r.FormValue("name")
-	file, fileHeader, err := r.FormFile("file")
-	if err != nil {
-		return fmt.Errorf("error retrieving formfile. error %s ", err.Error())
-	}
-	defer file.Close()
-	// file validation
-	fileName := fileHeader.Filename
-	allowedExt := ".geojson"
-	if path.Ext(fileName) != allowedExt {
-		return fmt.Errorf("The uploaded file must have the following extensions : %s ", allowedExt)
-	}
-	var maxFileSize int64 = 10_000_000
-	if fileHeader.Size > maxFileSize {
-		return fmt.Errorf("The uploaded file cannot be larger than %v", maxFileSize)
-	}
-	// file content processing
-	fileBytes, err := io.ReadAll(file)
-	if err != nil {
-		return fmt.Errorf("error file content processing. error : %s", err.Error())
-
-	}
-
-	if name == "" {
-		name = util.FileNameWithoutExtension(fileName)
-	}
-
-	err = s.store.CreateMasterMaps(name, &fileBytes)
-	if err != nil {
-		return fmt.Errorf("error when storing master maps. error : %s", err.Error())
-
-	}
-	return WriteJson(w, http.StatusOK, ApiSuccess{Message: fmt.Sprintf("File %s uploaded and processed successfully", fileName)})
-}
-
-func (s *Server) handleMasterMapsByName(w http.ResponseWriter, r *http.Request) error {
-	fmt.Println(r.Method)
-
-	switch r.Method {
-	case "GET":
-		return s.handleGetMasterMapsByName(w, r)
-	case "DELETE":
-		return s.handleDeleteMasterMap(w, r)
-	case "OPTIONS":
-		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
-		//default:
-		//http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
-	//return WriteJson(w, http.StatusMethodNotAllowed, ApiError{Error: "Method not allowed"})
-
-	return fmt.Errorf("Method not allowed")
-}
-func (s *Server) handleGetMasterMapsByName(w http.ResponseWriter, r *http.Request) error {
-	masterMap := mux.Vars(r)["name"]
-	if !util.AllNotNil(masterMap) {
-		return fmt.Errorf("API parameter is not complete.")
-	}
-	data, err := s.store.GetMasterMapByName(masterMap)
-	if err != nil {
-		return err
-	}
-	return WriteJson(w, http.StatusOK, data)
-}
-func (s *Server) handleDeleteMasterMap(w http.ResponseWriter, r *http.Request) error {
-	masterMap := mux.Vars(r)["name"]
-	if !util.AllNotNil(masterMap) {
-		return fmt.Errorf("request parameter not found. master_maps needed in the request.")
-	}
-	err := s.store.DeleteMasterMap(masterMap)
-	if err != nil {
-		return err
-	}
-	return WriteJson(w, http.StatusOK, ApiSuccess{Message: "Delete successfully"})
-}
-
-func (s *Server) handleMasterMapAttributes(w http.ResponseWriter, r *http.Request) error {
-	fmt.Println(r.Method)
-	switch r.Method {
-	case "GET":
-		return s.handleGetMasterMapAttributes(w, r)
-		//return WriteJson(w, http.StatusMethodNotAllowed, ApiError{Error: "Method not allowed"})
-
-	}
-	return fmt.Errorf("Method not allowed")
-}
-func (s *Server) handleGetMasterMapAttributes(w http.ResponseWriter, r *http.Request) error {
-	masterMap := mux.Vars(r)["name"]
-	if !util.AllNotNil(masterMap) {
-		return fmt.Errorf("API parameter is not complete.")
-	}
-	data, err := s.store.GetMasterMapAttributes(masterMap)
-	if err != nil {
-		return err
-	}
-	return WriteJson(w, http.StatusOK, data)
-}
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/nahrx/geomatis-api/storage"
+	"github.com/nahrx/geomatis-api/types"
+	"github.com/nahrx/geomatis-api/util"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+type Server struct {
+	listenAddr string
+	store      storage.Storage
+	detector   util.FeatureDetector
+	jobs       *jobQueue
+
+	importsMu sync.Mutex
+	imports   map[string]*types.ImportProgress
+}
+type ApiError struct {
+	Error string `json:"error"`
+}
+type ApiSuccess struct {
+	Message string `json:"message"`
+}
+type MasterMapImportResponse struct {
+	Message  string                 `json:"message"`
+	ImportId string                 `json:"import_id"`
+	Schema   *types.MasterMapSchema `json:"schema"`
+}
+type FeatureCollectionResponse struct {
+	Type           string             `json:"type"`
+	Name           string             `json:"name"`
+	CRS            *geojson.CRS       `json:"crs,omitempty"`
+	NumberMatched  int                `json:"numberMatched"`
+	NumberReturned int                `json:"numberReturned"`
+	Features       []*geojson.Feature `json:"features"`
+}
+type DryRunResponse struct {
+	Message string         `json:"message"`
+	Total   int            `json:"total"`
+	Success int            `json:"success"`
+	Fail    int            `json:"fail"`
+	Files   []types.Result `json:"files"`
+}
+type apiFunc func(http.ResponseWriter, *http.Request) error
+
+func makeHttpHandleFunc(f apiFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := f(w, r); err != nil {
+			WriteJson(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		}
+	}
+}
+func AddCorsHeader(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+}
+func WriteJson(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	AddCorsHeader(w)
+	w.WriteHeader(status)
+	fmt.Println(v)
+	return json.NewEncoder(w).Encode(v)
+}
+
+func ReqVars(r *http.Request) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	err := json.NewDecoder(r.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func NewServer(listenAddr string, store storage.Storage, detector util.FeatureDetector) *Server {
+	return &Server{
+		listenAddr: listenAddr,
+		store:      store,
+		detector:   detector,
+		jobs:       newJobQueue(),
+		imports:    map[string]*types.ImportProgress{},
+	}
+}
+
+func (s *Server) Start() error {
+	r := mux.NewRouter()
+	r.HandleFunc("/master-maps", makeHttpHandleFunc(s.handleMasterMaps))
+	r.HandleFunc("/master-maps/{name}", makeHttpHandleFunc(s.handleMasterMapsByName))
+	r.HandleFunc("/master-maps/{name}/attributes", makeHttpHandleFunc(s.handleMasterMapAttributes))
+	r.HandleFunc("/master-maps/{name}/export", makeHttpHandleFunc(s.handleMasterMapExport))
+	r.HandleFunc("/master-maps/{name}/features", makeHttpHandleFunc(s.handleMasterMapFeatures))
+	r.HandleFunc("/master-maps/{name}/features/{key}", makeHttpHandleFunc(s.handleMasterMapFeatureByKey))
+	r.HandleFunc("/master-maps/{name}/validate", makeHttpHandleFunc(s.handleMasterMapValidate))
+	r.HandleFunc("/master-maps/{name}/versions", makeHttpHandleFunc(s.handleMasterMapVersions))
+	r.HandleFunc("/master-maps/{name}/versions/{version}", makeHttpHandleFunc(s.handleMasterMapVersion))
+	r.HandleFunc("/master-maps/{name}/versions/{version}/activate", makeHttpHandleFunc(s.handleActivateMasterMapVersion))
+	r.HandleFunc("/master-maps/{name}/rollback", makeHttpHandleFunc(s.handleRollbackMasterMap))
+	r.HandleFunc("/georeference", makeHttpHandleFunc(s.handleGeoreference))
+	r.HandleFunc("/repos", makeHttpHandleFunc(s.handleRepos))
+	r.HandleFunc("/exports", makeHttpHandleFunc(s.handleExports))
+	r.HandleFunc("/jobs", makeHttpHandleFunc(s.handleJobs))
+	r.HandleFunc("/jobs/{id}", makeHttpHandleFunc(s.handleJobById))
+	r.HandleFunc("/imports", makeHttpHandleFunc(s.handleImports))
+
+	go s.runJobs()
+	if err := s.resumeJobs(); err != nil {
+		return fmt.Errorf("Error resumeJobs : %w", err)
+	}
+	return http.ListenAndServe(s.listenAddr, r)
+}
+
+func (s *Server) handleRepos(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+	switch r.Method {
+	case "POST":
+		return s.handleGetRepos(w, r)
+	case "DELETE":
+		return s.handleDeleteRepos(w, r)
+	case "PUT":
+		return s.handleUpdateRepos(w, r)
+	case "OPTIONS":
+		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
+	}
+	//return WriteJson(w, http.StatusMethodNotAllowed, ApiError{Error: "Method not allowed"})
+	return fmt.Errorf("Method not allowed")
+}
+
+type Dir struct {
+	Name  string `json:"name"`
+	IsDir bool   `json:"is_dir"`
+	Size  int64  `json:"size"`
+}
+
+func (s *Server) handleGetRepos(w http.ResponseWriter, r *http.Request) error {
+	vars, err := ReqVars(r)
+	if err != nil {
+		return fmt.Errorf("error ReqVars : %w", err)
+	}
+	vPath, ok := vars["path"]
+	if !ok {
+		vPath = ""
+	}
+	path := filepath.Join("uploads", vPath.(string))
+
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error os.Stat : %w", err)
+	}
+	if !pathInfo.IsDir() {
+		return fmt.Errorf("%s is not a directory.", path)
+	}
+
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return fmt.Errorf("error os.ReadDir : %w", err)
+	}
+	var list []Dir
+	for _, file := range files {
+		info, _ := file.Info()
+		file := Dir{
+			Name:  file.Name(),
+			IsDir: file.IsDir(),
+			Size:  info.Size(),
+		}
+		list = append(list, file)
+	}
+	return WriteJson(w, http.StatusOK, list)
+}
+func (s *Server) handleDeleteRepos(w http.ResponseWriter, r *http.Request) error {
+	vars, err := ReqVars(r)
+	if err != nil {
+		return err
+	}
+
+	vPath := vars["path"]
+	if !util.AllNotNil(vPath) {
+		return fmt.Errorf("API parameter is not complete.")
+	}
+
+	path := filepath.Join("uploads", vPath.(string))
+	fmt.Println(path)
+	err = os.RemoveAll(path)
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, ApiSuccess{Message: "Delete successfully"})
+}
+func (s *Server) handleUpdateRepos(w http.ResponseWriter, r *http.Request) error {
+	//defer r.Body.Close()
+	vars, err := ReqVars(r)
+	if err != nil {
+		return err
+	}
+	vPath := vars["path"]
+	vNewPath := vars["new_path"]
+	vMethod := vars["method"]
+
+	if !util.AllNotNil(vPath, vNewPath, vMethod) {
+		return fmt.Errorf("API parameter is not complete.")
+	}
+	path := filepath.Join("uploads/", vPath.(string))
+	newPath := filepath.Join("uploads/", vNewPath.(string))
+	method := vMethod.(string)
+
+	if method != "rename" {
+		return fmt.Errorf("method for updating repository not allowed")
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("path does not exist")
+	}
+	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+		return fmt.Errorf("newPath already exist")
+	}
+	if path == newPath {
+		return fmt.Errorf("path is not changed")
+	}
+	err = os.Rename(path, newPath)
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, ApiSuccess{Message: "rename successfully"})
+}
+func (s *Server) handleExports(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+	switch r.Method {
+	case "POST":
+		return s.handleDownload(w, r)
+	}
+	//return WriteJson(w, http.StatusMethodNotAllowed, ApiError{Error: "Method not allowed"})
+	return fmt.Errorf("Method not allowed")
+}
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) error {
+	vars, err := ReqVars(r)
+	vPath := vars["path"]
+	if err != nil {
+		return err
+	}
+	if !util.AllNotNil(vPath) {
+		return fmt.Errorf("API parameter is not complete.")
+	}
+	path := filepath.Join("uploads", vPath.(string))
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("File or directory not found")
+	}
+
+	AddCorsHeader(w)
+	w.WriteHeader(200)
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	if fileInfo.IsDir() {
+		// Zip the directory and serve as download
+		zipFileName := fileInfo.Name() + ".zip"
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", zipFileName))
+		zipWriter := zip.NewWriter(w)
+		defer zipWriter.Close()
+
+		err = util.ZipDirectory(path, zipWriter, path)
+		if err != nil {
+			return fmt.Errorf("Error ZipDirectory : %w", err)
+		}
+	} else {
+		// Serve the file directly
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileInfo.Name()))
+
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("Error os.Open : %w", err)
+		}
+		defer file.Close()
+
+		_, err = io.Copy(w, file)
+		if err != nil {
+			return fmt.Errorf("Error os.Copy : %w", err)
+		}
+	}
+
+	return nil
+}
+func (s *Server) handleGeoreference(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+	switch r.Method {
+	case "POST":
+		return s.handleCreateWorldFiles(w, r)
+	}
+	//return WriteJson(w, http.StatusMethodNotAllowed, ApiError{Error: "Method not allowed"})
+	return fmt.Errorf("Method not allowed")
+}
+func (s *Server) handleCreateWorldFiles(w http.ResponseWriter, r *http.Request) error {
+	var maxRequestBodySize int64 = 300 << 20 // 10*2^20 = 10 MB (pembulatan)
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	err := r.ParseMultipartForm(300 << 20) // grab the multipart form
+	if err != nil {
+		return fmt.Errorf("Error ParseMultipartForm : %w", err)
+	}
+	multipartForm := r.MultipartForm
+	if !util.AllNotNil(multipartForm) {
+		return fmt.Errorf("API parameter is not complete.")
+	}
+	rasters := multipartForm.File["rasters"]
+	if len(rasters) == 0 {
+		return fmt.Errorf("missing rasters file")
+	}
+
+	params := GeoreferenceParams(multipartForm)
+	geoSettings, err := s.NewGeoreferenceSettings(params)
+	if err != nil {
+		return err
+	}
+
+	if geoSettings.DryRun {
+		return s.dryRunGeoreference(w, geoSettings, rasters)
+	}
+
+	// rasters are staged outside TargetDir, the job runs after this request returns
+	job, err := s.NewJob(geoSettings, params, rasters)
+	if err != nil {
+		return err
+	}
+	s.enqueueJob(job.Id)
+	return WriteJson(w, http.StatusAccepted, job)
+}
+
+// Rasters scoring below the quality threshold are saved under this directory of
+// TargetDir instead of TargetDir itself.
+const needsReviewDir = "needs-review"
+const defaultQualityThreshold = 0.5
+
+func GetWorldFileExtlist() map[string]string {
+	return map[string]string{
+		".jpg":  ".jgw",
+		".jpeg": ".jgw",
+		".png":  ".pgw",
+		".tif":  ".tfw",
+		".tiff": ".tfw",
+		// formats without a conventional world file extension use the generic .wld
+		".bmp": ".wld",
+	}
+}
+func NewRasterKeySettings(category, prefixNumChar, suffixNumChar, regex string) (*types.RasterKeySettings, error) {
+	var err error
+	var rasterKey types.RasterKeySettings
+	rasterKey.Type = category
+	switch category {
+	case "all":
+		break
+	case "prefix":
+		rasterKey.NumChar, err = strconv.Atoi(prefixNumChar)
+		if err != nil {
+			return nil, fmt.Errorf("raster_key_prefix_num_char, Number of prefix raster key characters is not valid. error : %s.", err.Error())
+		}
+		if rasterKey.NumChar <= 0 {
+			return nil, fmt.Errorf("raster_key_prefix_num_char, Number of prefix raster key characters is not valid.")
+		}
+		break
+	case "suffix":
+		rasterKey.NumChar, err = strconv.Atoi(suffixNumChar)
+		if err != nil {
+			return nil, fmt.Errorf("raster_key_suffix_num_char, Number of suffix Raster key characters is not valid. error : %s.", err.Error())
+		}
+		if rasterKey.NumChar <= 0 {
+			return nil, fmt.Errorf("raster_key_prefix_num_char, Number of prefix raster key characters is not valid.")
+		}
+		break
+	case "regex":
+		rasterKey.Regex, err = regexp.Compile(regex)
+		if err != nil {
+			return nil, fmt.Errorf("Raster key regex is not valid. error : %s.", err.Error())
+		}
+		break
+	default:
+		return nil, fmt.Errorf("Type of raster key is not valid. Only all, prefix, suffix, or regex allowed.")
+	}
+	return &rasterKey, nil
+}
+func GetRasterKey(filename string, rasterKeySettings *types.RasterKeySettings) (string, error) {
+	filename = util.FileNameWithoutExtension(filename)
+	if len(filename) < rasterKeySettings.NumChar {
+		return "", fmt.Errorf("Length of string is not enough (less than rasterKeySettings.NumChar)")
+	}
+	switch rasterKeySettings.Type {
+	case "all":
+		return filename, nil
+	case "prefix":
+		return filename[:rasterKeySettings.NumChar], nil
+	case "suffix":
+		return filename[len(filename)-rasterKeySettings.NumChar:], nil
+	case "regex":
+		return rasterKeySettings.Regex.FindString(filename), nil
+	}
+	return "", fmt.Errorf("Type of raster key is not valid. Only all, prefix, suffix, or regex allowed.")
+}
+func NewRasterFeatureSettings(xPosition, yPosition, margin, marginLeft, marginRight, marginTop, marginBottom string) (*types.RasterFeatureSettings, error) {
+	switch xPosition {
+	case "":
+		xPosition = types.PositionCenter
+	case types.PositionLeft, types.PositionCenter, types.PositionRight:
+	default:
+		return nil, fmt.Errorf("feature_x_position, Feature x position is not valid. Only left, center, or right allowed.")
+	}
+	switch yPosition {
+	case "":
+		yPosition = types.PositionMiddle
+	case types.PositionTop, types.PositionMiddle, types.PositionBottom:
+	default:
+		return nil, fmt.Errorf("feature_y_position, Feature y position is not valid. Only top, middle, or bottom allowed.")
+	}
+
+	sides := []string{marginLeft, marginRight, marginTop, marginBottom}
+	var marginfloat64 float64
+	// feature_margin may be left out only when every side margin is given
+	if margin != "" || !util.AllNotNil(marginLeft, marginRight, marginTop, marginBottom) {
+		var err error
+		marginfloat64, err = strconv.ParseFloat(margin, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Feature margin is not valid. error : %s", err)
+		}
+	}
+	// every side defaults to half of the total margin
+	sideMargins := make([]float64, len(sides))
+	for i, side := range sides {
+		if side == "" {
+			sideMargins[i] = marginfloat64 / 2
+			continue
+		}
+		value, err := strconv.ParseFloat(side, 64)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("Feature side margin %s is not valid.", side)
+		}
+		sideMargins[i] = value
+	}
+	return &types.RasterFeatureSettings{
+		XPosition:    xPosition,
+		YPosition:    yPosition,
+		Margin:       marginfloat64,
+		MarginLeft:   sideMargins[0],
+		MarginRight:  sideMargins[1],
+		MarginTop:    sideMargins[2],
+		MarginBottom: sideMargins[3],
+	}, nil
+}
+
+// GeoreferenceParams keeps the first value of every form field. Jobs store these
+// params so the settings can be rebuilt when a job is resumed.
+func GeoreferenceParams(form *multipart.Form) map[string]string {
+	params := map[string]string{}
+	for key, values := range form.Value {
+		if len(values) > 0 {
+			params[key] = values[0]
+		}
+	}
+	return params
+}
+
+func (s *Server) NewGeoreferenceSettings(params map[string]string) (*types.GeoreferenceSettings, error) {
+	masterMap := params["master_map"]
+	attrKey := params["attr_key"]
+	rasterKeyType := params["raster_key_type"]
+	rasterKeyPrefixNumChar := params["raster_key_prefix_num_char"]
+	rasterKeySuffixNumChar := params["raster_key_suffix_num_char"]
+	rasterKeyRegex := params["raster_key_regex"]
+	targetDir := params["target_dir"]
+	separateDir := params["separate_dir"]
+	featureXPosition := params["feature_x_position"]
+	featureYPosition := params["feature_y_position"]
+	featureMargin := params["feature_margin"]
+	featureMarginLeft := params["feature_margin_left"]
+	featureMarginRight := params["feature_margin_right"]
+	featureMarginTop := params["feature_margin_top"]
+	featureMarginBottom := params["feature_margin_bottom"]
+	qualityThreshold := params["quality_threshold"]
+	targetSrid := params["target_srid"]
+	writePrj := params["write_prj"]
+	writeAuxXml := params["write_aux_xml"]
+	allowAmbiguousKey := params["allow_ambiguous_key"]
+	dryRun := params["dry_run"]
+	debugOverlay := params["debug_overlay"]
+	refine := params["refine"]
+	rectify := params["rectify"]
+	outputFormat := params["output_format"]
+	northUp := params["north_up"]
+
+	fmt.Println(masterMap)
+	fmt.Println(attrKey)
+	fmt.Println(rasterKeyType)
+	fmt.Println(targetDir)
+	fmt.Println(separateDir)
+
+	if masterMap == "" {
+		return nil, fmt.Errorf("missing master_map parameter")
+	}
+	if attrKey == "" {
+		return nil, fmt.Errorf("missing attr_key parameter")
+	}
+	if rasterKeyType == "" {
+		return nil, fmt.Errorf("missing raster_key_type parameter")
+	}
+
+	masterMapExist, err := s.store.MasterMapExist(masterMap)
+	if err != nil {
+		return nil, fmt.Errorf("Error when calling MasterMapExist. Error :  %s", err.Error())
+	}
+	if !masterMapExist {
+		return nil, fmt.Errorf("%s is not found in the database.", masterMap)
+	}
+
+	attrKeyExist, err := s.store.MasterMapAttributeExist(masterMap, attrKey)
+	if err != nil {
+		return nil, fmt.Errorf("Error when calling MasterMapExist. Error :  %s", err.Error())
+	}
+	if !attrKeyExist {
+		return nil, fmt.Errorf("%s is not found in %s.", attrKey, masterMap)
+	}
+	rasterKey, err := NewRasterKeySettings(rasterKeyType, rasterKeyPrefixNumChar, rasterKeySuffixNumChar, rasterKeyRegex)
+	if err != nil {
+		return nil, fmt.Errorf("Error when calling NewRasterKeySettings. Error :  %s", err.Error())
+	}
+
+	var separateDirArray []string
+	if separateDir == "" {
+		separateDir = "[]"
+	}
+
+	if err := json.Unmarshal([]byte(separateDir), &separateDirArray); err != nil {
+		return nil, fmt.Errorf("Error Unmarshal separateDir Json. Error : %s", err.Error())
+	}
+
+	regexValidDir, err := regexp.Compile("([a-zA-Z0-9 \\/])\\w+")
+	if err != nil {
+		return nil, fmt.Errorf("Error regexp.Compile. Error : %s", err.Error())
+	}
+	targetDir = filepath.Join("uploads", targetDir)
+	if !regexValidDir.MatchString(targetDir) {
+		return nil, fmt.Errorf("Target directory name must be valid.")
+	}
+
+	rasterFeature, err := NewRasterFeatureSettings(featureXPosition, featureYPosition, featureMargin, featureMarginLeft, featureMarginRight, featureMarginTop, featureMarginBottom)
+	if err != nil {
+		return nil, fmt.Errorf("Error when calling NewRasterFeatureSettings. Error : %s", err.Error())
+	}
+	threshold := defaultQualityThreshold
+	if qualityThreshold != "" {
+		threshold, err = strconv.ParseFloat(qualityThreshold, 64)
+		if err != nil || threshold < 0 || threshold > 1 {
+			return nil, fmt.Errorf("quality_threshold, Quality threshold must be a number between 0 and 1.")
+		}
+	}
+
+	// world files are written in the master map CRS unless target_srid is given
+	mm, err := s.store.GetMasterMapByName(masterMap)
+	if err != nil {
+		return nil, fmt.Errorf("Error when calling GetMasterMapByName. Error :  %s", err.Error())
+	}
+	srid := mm.Srid
+	var targetSridNum int
+	if targetSrid != "" {
+		targetSridNum, err = strconv.Atoi(targetSrid)
+		if err != nil || targetSridNum <= 0 {
+			return nil, fmt.Errorf("target_srid, Target SRID must be a positive integer.")
+		}
+		srid = targetSridNum
+	}
+	spatialReference, err := s.spatialReference(srid)
+	if err != nil {
+		return nil, fmt.Errorf("Error when calling GetSpatialReference. Error :  %s", err.Error())
+	}
+	prj, auxXml := true, false
+	if writePrj != "" {
+		if prj, err = strconv.ParseBool(writePrj); err != nil {
+			return nil, fmt.Errorf("write_prj, Write prj must be true or false.")
+		}
+	}
+	if writeAuxXml != "" {
+		if auxXml, err = strconv.ParseBool(writeAuxXml); err != nil {
+			return nil, fmt.Errorf("write_aux_xml, Write aux xml must be true or false.")
+		}
+	}
+	ambiguous := false
+	if allowAmbiguousKey != "" {
+		if ambiguous, err = strconv.ParseBool(allowAmbiguousKey); err != nil {
+			return nil, fmt.Errorf("allow_ambiguous_key, Allow ambiguous key must be true or false.")
+		}
+	}
+	dry := false
+	if dryRun != "" {
+		if dry, err = strconv.ParseBool(dryRun); err != nil {
+			return nil, fmt.Errorf("dry_run, Dry run must be true or false.")
+		}
+	}
+	overlay := false
+	if debugOverlay != "" {
+		if overlay, err = strconv.ParseBool(debugOverlay); err != nil {
+			return nil, fmt.Errorf("debug_overlay, Debug overlay must be true or false.")
+		}
+	}
+	refineOutline := false
+	if refine != "" {
+		if refineOutline, err = strconv.ParseBool(refine); err != nil {
+			return nil, fmt.Errorf("refine, Refine must be true or false.")
+		}
+	}
+	rectifyRaster := false
+	if rectify != "" {
+		if rectifyRaster, err = strconv.ParseBool(rectify); err != nil {
+			return nil, fmt.Errorf("rectify, Rectify must be true or false.")
+		}
+	}
+	switch outputFormat {
+	case "":
+		outputFormat = types.OutputWorldFile
+	case types.OutputWorldFile, types.OutputGeoTiff, types.OutputBoth:
+	default:
+		return nil, fmt.Errorf("output_format, Output format must be %s, %s or %s.", types.OutputWorldFile, types.OutputGeoTiff, types.OutputBoth)
+	}
+	northUpTiff := false
+	if northUp != "" {
+		if northUpTiff, err = strconv.ParseBool(northUp); err != nil {
+			return nil, fmt.Errorf("north_up, North up must be true or false.")
+		}
+	}
+	return &types.GeoreferenceSettings{
+		MasterMap:             masterMap,
+		AttrKey:               attrKey,
+		RasterKeySettings:     rasterKey,
+		TargetDir:             targetDir,
+		SeparateDirAttrs:      separateDirArray,
+		RasterFeatureSettings: rasterFeature,
+		QualityThreshold:      threshold,
+		TargetSrid:            targetSridNum,
+		Srid:                  srid,
+		SpatialReference:      spatialReference,
+		WritePrj:              prj,
+		AllowAmbiguousKey:     ambiguous,
+		DryRun:                dry,
+		DebugOverlay:          overlay,
+		Refine:                refineOutline,
+		Rectify:               rectifyRaster,
+		OutputFormat:          outputFormat,
+		NorthUp:               northUpTiff,
+		WriteAuxXml:           auxXml,
+	}, nil
+}
+
+type indexedRaster struct {
+	index  int
+	raster types.RasterFile
+}
+type indexedResult struct {
+	index  int
+	result types.Result
+}
+
+func (s *Server) worker(id int, gRaster <-chan indexedRaster, results chan<- indexedResult, g *types.GeoreferenceSettings) {
+	fmt.Println("worker : ", id)
+	for r := range gRaster {
+		results <- indexedResult{index: r.index, result: s.georeferenceRaster(r.raster, g)}
+	}
+}
+
+// spatialReference returns the WKT of srid, bundled definitions first and
+// spatial_ref_sys for the other codes.
+func (s *Server) spatialReference(srid int) (string, error) {
+	if wkt, ok := util.SpatialReferenceWkt(srid); ok {
+		return wkt, nil
+	}
+	return s.store.GetSpatialReference(srid)
+}
+
+// dryRunGeoreference georeferences the rasters from a temporary directory and
+// answers with the planned paths and parameters, nothing is written under TargetDir.
+func (s *Server) dryRunGeoreference(w http.ResponseWriter, g *types.GeoreferenceSettings, rasters []*multipart.FileHeader) error {
+	stageDir, err := os.MkdirTemp("", "dry-run-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stageDir)
+	request := &types.GeoreferenceRequest{Settings: g}
+	for i, fileHeader := range rasters {
+		filename := filepath.Base(fileHeader.Filename)
+		rasterPath := filepath.Join(stageDir, stagedFileName(i, filename))
+		if err := util.SaveFile(rasterPath, fileHeader); err != nil {
+			return fmt.Errorf("Failed to save file %s. error : %s.", filename, err.Error())
+		}
+		request.Raster = append(request.Raster, types.RasterFile{Filename: filename, Path: rasterPath})
+	}
+	response := DryRunResponse{
+		Message: "Dry run, nothing is written",
+		Files:   s.GeoreferenceRasterFiles(request, nil),
+	}
+	for _, result := range response.Files {
+		response.Total++
+		if result.Status == types.JobFailed {
+			response.Fail++
+		} else {
+			response.Success++
+		}
+	}
+	return WriteJson(w, http.StatusOK, response)
+}
+func (s *Server) georeferenceRaster(raster types.RasterFile, g *types.GeoreferenceSettings) types.Result {
+	result := types.Result{
+		Filename: raster.Filename,
+		Status:   types.JobDone,
+	}
+	fail := func(code string, err error) types.Result {
+		result.Status = types.JobFailed
+		result.ErrorCode = code
+		result.ErrorMessage = err.Error()
+		result.Error = err
+		return result
+	}
+	// a raster without a known world file extension can not be read back by GIS software
+	if _, ok := GetWorldFileExtlist()[strings.ToLower(path.Ext(raster.Filename))]; !ok {
+		return fail(types.ErrUnsupportedRaster, fmt.Errorf("Raster extension %s is not supported. Only .jpg, .jpeg, .png, .tif, .tiff or .bmp allowed.", path.Ext(raster.Filename)))
+	}
+	//Get image dimension
+	file1, err := os.Open(raster.Path)
+	if err != nil {
+		return fail(types.ErrOpenRaster, fmt.Errorf("Error while opening raster file. error : %s.", err.Error()))
+	}
+	defer file1.Close()
+	file2, err := os.Open(raster.Path)
+	if err != nil {
+		return fail(types.ErrOpenRaster, fmt.Errorf("Error while opening raster file. error : %s.", err.Error()))
+	}
+	defer file2.Close()
+
+	imgDim, err := util.GetOrientedImageDimensions(file1, file2)
+	if err != nil {
+		return fail(types.ErrImageDimension, fmt.Errorf("Error GetOrientationTag : %w", err))
+	}
+	//Get raster key
+	rasterKey, err := GetRasterKey(raster.Filename, g.RasterKeySettings)
+	if err != nil {
+		return fail(types.ErrRasterKey, fmt.Errorf("Error GetRasterKey: %s.", err.Error()))
+	}
+	result.RasterKey = rasterKey
+
+	// a key matching several features would silently merge their extents
+	matched, err := s.store.CountMasterMapFeatures(g.MasterMap, g.AttrKey, rasterKey)
+	if err != nil {
+		return fail(types.ErrAttributesValue, fmt.Errorf("Error CountMasterMapFeatures : %s.", err.Error()))
+	}
+	if matched == 0 {
+		return fail(types.ErrKeyNotFound, fmt.Errorf("No feature of %s has %s = %s.", g.MasterMap, g.AttrKey, rasterKey))
+	}
+	if matched > 1 && !g.AllowAmbiguousKey {
+		return fail(types.ErrAmbiguousKey, fmt.Errorf("%v features of %s have %s = %s, send allow_ambiguous_key=true to use their combined extent.", matched, g.MasterMap, g.AttrKey, rasterKey))
+	}
+
+	//Get separateDir attributes and save file
+	separateDirName, err := s.store.GetAttributesValue(g.MasterMap, g.AttrKey, rasterKey, g.SeparateDirAttrs)
+	if err != nil {
+		return fail(types.ErrAttributesValue, fmt.Errorf("Error GetAttributesValue : %s.", err.Error()))
+	}
+	result.Feature = fmt.Sprintf("%s=%s", g.AttrKey, rasterKey)
+
+	//Get polygon extent, raster feature point from image. The active version is
+	//read per raster with the extent, a version may be activated while a job runs
+	polygonExtent, version, err := s.store.GetExtent(g.MasterMap, g.AttrKey, rasterKey, g.TargetSrid)
+	if err != nil {
+		return fail(types.ErrExtent, fmt.Errorf("Error GetExtent : %s.", err.Error()))
+	}
+	result.Extent = polygonExtent
+	result.MasterMapVersion = version
+
+	featurePoints, err := s.detector.GetRasterFeaturePoints(raster.Path)
+	if err != nil {
+		return fail(types.ErrFeatureDetection, fmt.Errorf("Error GetRasterFeaturePoints : %s.", err.Error()))
+	}
+
+	// a frame that is not a parallelogram comes from a photo taken at an angle, no
+	// affine world file fits it, so the raster is rectified to a temporary copy and
+	// georeferenced from there
+	perspective, err := util.PerspectiveDistortion(featurePoints)
+	if err != nil {
+		return fail(types.ErrFeatureDetection, fmt.Errorf("Error PerspectiveDistortion : %s.", err.Error()))
+	}
+	result.Perspective = perspective
+	rasterPath := raster.Path
+	rasterFilename := raster.Filename
+	if g.Rectify && perspective > util.PerspectiveTolerance {
+		ext := strings.ToLower(path.Ext(raster.Filename))
+		if ext != ".jpg" && ext != ".jpeg" && ext != ".png" {
+			ext = ".png"
+		}
+		rectifiedFile, err := os.CreateTemp("", "rectified-*"+ext)
+		if err != nil {
+			return fail(types.ErrRectify, fmt.Errorf("Failed to create rectified raster. error : %s.", err.Error()))
+		}
+		rectifiedFile.Close()
+		defer os.Remove(rectifiedFile.Name())
+		featurePoints, imgDim, err = util.RectifyRaster(rectifiedFile.Name(), raster.Path, featurePoints)
+		if err != nil {
+			return fail(types.ErrRectify, fmt.Errorf("Error RectifyRaster : %s.", err.Error()))
+		}
+		rasterPath = rectifiedFile.Name()
+		rasterFilename = fmt.Sprintf("%s.rectified%s", util.FileNameWithoutExtension(raster.Filename), ext)
+		result.Rectified = true
+	}
+
+	//Calculate Georeference Parameter and check its quality
+	fit, err := util.CalculateGeoreferenceParameters(imgDim, featurePoints, *polygonExtent, g.RasterFeatureSettings)
+	if err != nil {
+		return fail(types.ErrGeoreference, fmt.Errorf("Error CalculateGeoreferenceParameters : %s.", err.Error()))
+	}
+	// the residuals and the quality describe the fit that is written, they are
+	// evaluated again when a refinement replaces it. A refined fit is scored by
+	// its outline distance, its corners leave the bounding box on purpose
+	evaluate := func(fit *types.GeoreferenceFit, refinement *types.Refinement) (*types.Quality, error) {
+		var quality *types.Quality
+		var err error
+		if refinement != nil {
+			quality, err = util.EvaluateRefinedQuality(imgDim, featurePoints, *polygonExtent, refinement)
+		} else {
+			quality, err = util.EvaluateGeoreferenceQuality(imgDim, featurePoints, *polygonExtent, fit)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error EvaluateGeoreferenceQuality : %s.", err.Error())
+		}
+		if perspective > util.PerspectiveTolerance && !result.Rectified {
+			quality.Reasons = append(quality.Reasons, fmt.Sprintf("Frame is not a parallelogram (perspective %.3f), send rectify=true to georeference a rectified copy", perspective))
+		}
+		result.Parameter = &fit.Parameter
+		result.Residuals = fit.Residuals
+		result.RMSE = fit.RMSE
+		result.Quality = quality
+		return quality, nil
+	}
+	quality, err := evaluate(fit, nil)
+	if err != nil {
+		return fail(types.ErrGeoreference, err)
+	}
+
+	// the polygon outline refines the bounding box fit, it is kept only when it
+	// brings the outline closer to the printed boundary
+	var polygon geom.T
+	if g.Refine || g.DebugOverlay {
+		polygon, err = s.store.GetFeatureGeometry(g.MasterMap, g.AttrKey, rasterKey, g.TargetSrid)
+		if err != nil {
+			return fail(types.ErrExtent, fmt.Errorf("Error GetFeatureGeometry : %s.", err.Error()))
+		}
+	}
+	if g.Refine {
+		// a raster the outline can not be matched on keeps the bounding box fit
+		refinement, err := util.RefineGeoreference(rasterPath, featurePoints, fit, g.RasterFeatureSettings, polygon)
+		if err != nil {
+			refinement = &types.Refinement{Error: err.Error()}
+		}
+		result.Refinement = refinement
+		if refinement.Applied {
+			fit = util.RefitParameter(fit, refinement.Parameter)
+			if quality, err = evaluate(fit, refinement); err != nil {
+				return fail(types.ErrGeoreference, err)
+			}
+		}
+	}
+
+	//Save file and world file, suspicious georeferences are kept apart for review
+	dir := strings.Join(separateDirName, "/")
+	targetDir := filepath.Join(g.TargetDir, dir)
+	if quality.Score < g.QualityThreshold {
+		result.NeedsReview = true
+		targetDir = filepath.Join(g.TargetDir, needsReviewDir, dir)
+		if len(quality.Reasons) == 0 {
+			quality.Reasons = append(quality.Reasons, fmt.Sprintf("Quality score %.2f is below %.2f", quality.Score, g.QualityThreshold))
+		}
+	}
+	filePath := filepath.Join(targetDir, rasterFilename)
+	originalPath := ""
+	if result.Rectified {
+		originalPath = filepath.Join(targetDir, raster.Filename)
+	}
+	worldFileExt := GetWorldFileExtlist()[strings.ToLower(path.Ext(rasterFilename))]
+	fmt.Println("worldFileExt : ", worldFileExt)
+	worldFileName := fmt.Sprintf("%s%s", util.FileNameWithoutExtension(filePath), worldFileExt)
+	prjFileName := fmt.Sprintf("%s.prj", util.FileNameWithoutExtension(filePath))
+	auxXmlFileName := filePath + ".aux.xml"
+	overlayFileName := fmt.Sprintf("%s.overlay.png", util.FileNameWithoutExtension(filePath))
+	geoTiffFileName := fmt.Sprintf("%s.tif", util.FileNameWithoutExtension(filePath))
+	if worldFileExt == ".tfw" {
+		// keeps the copy of a tiff raster apart from the GeoTIFF
+		geoTiffFileName = fmt.Sprintf("%s.geo.tif", util.FileNameWithoutExtension(filePath))
+	}
+	// a GeoTIFF alone is self-contained, the raster copy, world file and sidecars are skipped
+	worldFile := g.OutputFormat != types.OutputGeoTiff
+	geoTiff := g.OutputFormat != types.OutputWorldFile
+	result.Srid = g.Srid
+
+	if g.DryRun {
+		// nothing is written, the paths tell where a real run would put the files
+		result.OriginalPath = originalPath
+		if worldFile {
+			result.RasterPath = filePath
+			result.WorldFilePath = worldFileName
+			if g.WritePrj {
+				result.PrjPath = prjFileName
+			}
+			if g.WriteAuxXml {
+				result.AuxXmlPath = auxXmlFileName
+			}
+		}
+		if geoTiff {
+			result.GeoTiffPath = geoTiffFileName
+		}
+		if g.DebugOverlay {
+			result.OverlayPath = overlayFileName
+		}
+		return result
+	}
+
+	if err := os.MkdirAll(targetDir, os.ModePerm); err != nil {
+		return fail(types.ErrTargetDir, fmt.Errorf("Failed to create directory %s. error : %s.", targetDir, err.Error()))
+	}
+
+	if result.Rectified {
+		// the original is kept untouched next to the rectified copy, without a world file
+		err = util.CopyFile(originalPath, raster.Path)
+		if err != nil {
+			return fail(types.ErrSaveRaster, fmt.Errorf("Failed to save original file. error : %s.", err.Error()))
+		}
+		result.OriginalPath = originalPath
+	}
+
+	if worldFile {
+		err = util.CopyFile(filePath, rasterPath)
+		if err != nil {
+			return fail(types.ErrSaveRaster, fmt.Errorf("Failed to save file. error : %s.", err.Error()))
+		}
+		result.RasterPath = filePath
+
+		err = util.WriteWorldFileParametersToFile(worldFileName, fit.Parameter)
+		if err != nil {
+			return fail(types.ErrWorldFile, fmt.Errorf("Error while creating worldfile. error : %s.", err.Error()))
+		}
+		result.WorldFilePath = worldFileName
+
+		if g.WritePrj {
+			err = util.WritePrjFile(prjFileName, g.SpatialReference)
+			if err != nil {
+				return fail(types.ErrSidecar, fmt.Errorf("Error while creating prj file. error : %s.", err.Error()))
+			}
+			result.PrjPath = prjFileName
+		}
+		if g.WriteAuxXml {
+			err = util.WriteAuxXmlFile(auxXmlFileName, g.SpatialReference, fit.Parameter)
+			if err != nil {
+				return fail(types.ErrSidecar, fmt.Errorf("Error while creating aux.xml file. error : %s.", err.Error()))
+			}
+			result.AuxXmlPath = auxXmlFileName
+		}
+	}
+	if geoTiff {
+		err = util.WriteGeoTiff(geoTiffFileName, rasterPath, fit.Parameter, g.Srid, g.SpatialReference, g.NorthUp)
+		if err != nil {
+			return fail(types.ErrGeoTiff, fmt.Errorf("Error while creating GeoTIFF. error : %s.", err.Error()))
+		}
+		result.GeoTiffPath = geoTiffFileName
+	}
+	if g.DebugOverlay {
+		err = util.WriteDebugOverlay(overlayFileName, rasterPath, featurePoints, fit, g.RasterFeatureSettings, polygon)
+		if err != nil {
+			return fail(types.ErrDebugOverlay, fmt.Errorf("Error while creating debug overlay. error : %s.", err.Error()))
+		}
+		result.OverlayPath = overlayFileName
+	}
+	return result
+}
+
+// GeoreferenceRasterFiles runs the rasters through a pool of workers and returns one
+// result per raster in the same order. onResult is called from a single goroutine
+// as soon as each raster is finished.
+func (s *Server) GeoreferenceRasterFiles(g *types.GeoreferenceRequest, onResult func(int, types.Result)) []types.Result {
+	numJobs := len(g.Raster)
+	numWorkers := 20
+	if numJobs < numWorkers {
+		numWorkers = numJobs
+	}
+	rasters := make(chan indexedRaster, numJobs)
+	results := make(chan indexedResult, numJobs)
+	for w := 0; w < numWorkers; w++ {
+		go s.worker(w, rasters, results, g.Settings)
+	}
+
+	for j := 0; j < numJobs; j++ {
+		rasters <- indexedRaster{index: j, raster: g.Raster[j]}
+	}
+	close(rasters)
+
+	values := make([]types.Result, numJobs)
+	for a := 0; a < numJobs; a++ {
+		r := <-results
+		values[r.index] = r.result
+		if onResult != nil {
+			onResult(r.index, r.result)
+		}
+	}
+	return values
+}
+func (s *Server) handleMasterMaps(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+	switch r.Method {
+	case "GET":
+		return s.handleGetMasterMaps(w, r)
+	case "POST":
+		return s.handleCreateMasterMaps(w, r)
+	case "OPTIONS":
+		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
+		//default:
+		//http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+	//return WriteJson(w, http.StatusMethodNotAllowed, ApiError{Error: "Method not allowed"})
+	return fmt.Errorf("Method not allowed")
+}
+func (s *Server) handleGetMasterMaps(w http.ResponseWriter, r *http.Request) error {
+	data, err := s.store.GetMasterMaps()
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, data)
+}
+func (s *Server) handleCreateMasterMaps(w http.ResponseWriter, r *http.Request) error {
+	maxFileSize, err := masterMapMaxSize()
+	if err != nil {
+		return err
+	}
+	// the body is streamed part by part, the layer file is spooled to disk and
+	// never read into memory as a whole
+	r.Body = http.MaxBytesReader(w, r.Body, maxFileSize)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return fmt.Errorf("error reading multipart form. error %s ", err.Error())
+	}
+	// the multipart fields are read after the upload, so the import id comes
+	// with the query string
+	progress, err := s.startImport(r.URL.Query().Get("import_id"))
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		s.updateImport(progress, func(p *types.ImportProgress) {
+			p.Stage = types.ImportFailed
+			p.Err = err.Error()
+		})
+		return err
+	}
+
+	fields := map[string]string{}
+	var fileName, filePath string
+	defer func() {
+		if filePath != "" {
+			os.Remove(filePath)
+		}
+	}()
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(uploadError(err, maxFileSize))
+		}
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, 1<<20))
+			if err != nil {
+				return fail(uploadError(err, maxFileSize))
+			}
+			fields[part.FormName()] = string(value)
+			continue
+		}
+		if part.FormName() != "file" || fileName != "" {
+			continue
+		}
+		// file validation
+		fileName = filepath.Base(part.FileName())
+		ext := strings.ToLower(path.Ext(fileName))
+		allowedExt := ".geojson, .json, .zip, .gpkg"
+		if ext != ".geojson" && ext != ".json" && ext != ".zip" && ext != ".gpkg" {
+			return fail(fmt.Errorf("The uploaded file must have the following extensions : %s ", allowedExt))
+		}
+		s.updateImport(progress, func(p *types.ImportProgress) { p.Filename = fileName })
+		filePath, err = s.spoolUpload(part, ext, progress)
+		if err != nil {
+			return fail(uploadError(err, maxFileSize))
+		}
+	}
+	if fileName == "" {
+		return fail(fmt.Errorf("error retrieving formfile. file is missing"))
+	}
+
+	name := fields["name"]
+	if name == "" {
+		name = util.FileNameWithoutExtension(fileName)
+	}
+	s.updateImport(progress, func(p *types.ImportProgress) { p.Name = name })
+
+	settings := &types.MasterMapImportSettings{
+		Name:    name,
+		AttrKey: fields["attr_key"],
+		Preview: fields["preview"] == "true",
+		// a new version of an existing master map, activated right away with activate=true
+		Version:  fields["version"],
+		Activate: fields["activate"] == "true",
+		// validate=true reports duplicate and null keys and bad geometries of attr_key
+		Validate: fields["validate"] == "true",
+		OnProgress: func(stage string, features int) {
+			s.updateImport(progress, func(p *types.ImportProgress) {
+				p.Stage = stage
+				p.Features = features
+			})
+		},
+	}
+	var layer *util.Layer
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".geojson", ".json":
+		layer, err = util.OpenGeoJSON(filePath)
+		if err != nil {
+			return fail(fmt.Errorf("error reading geojson. error : %s", err.Error()))
+		}
+	case ".zip":
+		layer, err = readShapefileUpload(filePath, fields["layer"], maxFileSize)
+		if err != nil {
+			return fail(fmt.Errorf("error reading shapefile. error : %s", err.Error()))
+		}
+	case ".gpkg":
+		layer, err = util.ReadGeoPackage(filePath, fields["layer"])
+		if err != nil {
+			return fail(fmt.Errorf("error reading geopackage. error : %s", err.Error()))
+		}
+	}
+	settings.Srid = layer.Srid
+	if srid := fields["srid"]; srid != "" {
+		settings.Srid, err = strconv.Atoi(srid)
+		if err != nil || settings.Srid <= 0 {
+			return fail(fmt.Errorf("srid, SRID must be a positive integer."))
+		}
+	}
+	if settings.Srid == 0 {
+		return fail(fmt.Errorf("The CRS of %s is not recognized, send the srid field.", fileName))
+	}
+	schema, err := s.store.CreateMasterMaps(settings, layer.Each)
+	if err != nil {
+		return fail(fmt.Errorf("error when storing master maps. error : %s", err.Error()))
+
+	}
+	s.updateImport(progress, func(p *types.ImportProgress) {
+		p.Stage = types.ImportDone
+		p.Features = schema.Features
+	})
+	message := fmt.Sprintf("File %s uploaded and processed successfully", fileName)
+	if settings.Preview {
+		message = fmt.Sprintf("Schema of file %s inferred, nothing is stored", fileName)
+	}
+	return WriteJson(w, http.StatusOK, MasterMapImportResponse{Message: message, ImportId: progress, Schema: schema})
+}
+
+func (s *Server) handleMasterMapsByName(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+
+	switch r.Method {
+	case "GET":
+		return s.handleGetMasterMapsByName(w, r)
+	case "DELETE":
+		return s.handleDeleteMasterMap(w, r)
+	case "OPTIONS":
+		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
+		//default:
+		//http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+	//return WriteJson(w, http.StatusMethodNotAllowed, ApiError{Error: "Method not allowed"})
+
+	return fmt.Errorf("Method not allowed")
+}
+func (s *Server) handleGetMasterMapsByName(w http.ResponseWriter, r *http.Request) error {
+	masterMap := mux.Vars(r)["name"]
+	if !util.AllNotNil(masterMap) {
+		return fmt.Errorf("API parameter is not complete.")
+	}
+	data, err := s.store.GetMasterMapByName(masterMap)
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, data)
+}
+func (s *Server) handleDeleteMasterMap(w http.ResponseWriter, r *http.Request) error {
+	masterMap := mux.Vars(r)["name"]
+	if !util.AllNotNil(masterMap) {
+		return fmt.Errorf("request parameter not found. master_maps needed in the request.")
+	}
+	err := s.store.DeleteMasterMap(masterMap)
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, ApiSuccess{Message: "Delete successfully"})
+}
+
+func (s *Server) handleMasterMapAttributes(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+	switch r.Method {
+	case "GET":
+		return s.handleGetMasterMapAttributes(w, r)
+		//return WriteJson(w, http.StatusMethodNotAllowed, ApiError{Error: "Method not allowed"})
+
+	}
+	return fmt.Errorf("Method not allowed")
+}
+func (s *Server) handleGetMasterMapAttributes(w http.ResponseWriter, r *http.Request) error {
+	masterMap := mux.Vars(r)["name"]
+	if !util.AllNotNil(masterMap) {
+		return fmt.Errorf("API parameter is not complete.")
+	}
+	data, err := s.store.GetMasterMapAttributes(masterMap)
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, data)
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/nahrx/geomatis-api/util"
+)
+
+func (s *Server) handleMasterMapVersions(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+	switch r.Method {
+	case "GET":
+		return s.handleGetMasterMapVersions(w, r)
+	case "OPTIONS":
+		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
+	}
+	return fmt.Errorf("Method not allowed")
+}
+
+func (s *Server) handleMasterMapVersion(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+	switch r.Method {
+	case "DELETE":
+		return s.handleDeleteMasterMapVersion(w, r)
+	case "OPTIONS":
+		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
+	}
+	return fmt.Errorf("Method not allowed")
+}
+
+func (s *Server) handleActivateMasterMapVersion(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+	switch r.Method {
+	case "POST":
+		return s.handlePostActivateMasterMapVersion(w, r)
+	case "OPTIONS":
+		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
+	}
+	return fmt.Errorf("Method not allowed")
+}
+
+func (s *Server) handleRollbackMasterMap(w http.ResponseWriter, r *http.Request) error {
+	fmt.Println(r.Method)
+	switch r.Method {
+	case "POST":
+		return s.handlePostRollbackMasterMap(w, r)
+	case "OPTIONS":
+		return WriteJson(w, http.StatusOK, ApiSuccess{Message: "OPTIONS return successfully"})
+	}
+	return fmt.Errorf("Method not allowed")
+}
+
+func (s *Server) handleGetMasterMapVersions(w http.ResponseWriter, r *http.Request) error {
+	masterMap := mux.Vars(r)["name"]
+	if !util.AllNotNil(masterMap) {
+		return fmt.Errorf("API parameter is not complete.")
+	}
+	data, err := s.store.GetMasterMapVersions(masterMap)
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, data)
+}
+
+func (s *Server) handleDeleteMasterMapVersion(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	masterMap, version := vars["name"], vars["version"]
+	if !util.AllNotNil(masterMap, version) {
+		return fmt.Errorf("API parameter is not complete.")
+	}
+	if err := s.store.DeleteMasterMapVersion(masterMap, version); err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, ApiSuccess{Message: "Delete successfully"})
+}
+
+// handlePostActivateMasterMapVersion makes a version the one georeference reads,
+// the previously active version is kept and can be rolled back to.
+func (s *Server) handlePostActivateMasterMapVersion(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	masterMap, version := vars["name"], vars["version"]
+	if !util.AllNotNil(masterMap, version) {
+		return fmt.Errorf("API parameter is not complete.")
+	}
+	if err := s.store.ActivateMasterMapVersion(masterMap, version); err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, ApiSuccess{Message: fmt.Sprintf("Version %s of %s is active", version, masterMap)})
+}
+
+// handlePostRollbackMasterMap activates the version that was active before.
+func (s *Server) handlePostRollbackMasterMap(w http.ResponseWriter, r *http.Request) error {
+	masterMap := mux.Vars(r)["name"]
+	if !util.AllNotNil(masterMap) {
+		return fmt.Errorf("API parameter is not complete.")
+	}
+	version, err := s.store.RollbackMasterMap(masterMap)
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, ApiSuccess{Message: fmt.Sprintf("%s is rolled back to version %s", masterMap, version)})
+}
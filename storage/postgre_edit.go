@@ -0,0 +1,393 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/ewkbhex"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+// updatedAtColumn is set to now() by a trigger whenever a feature changes.
+const updatedAtColumn = "updated_at"
+
+// ErrFeatureNotFound is returned when no feature of a master map has the key.
+var ErrFeatureNotFound = errors.New("Feature is not found")
+
+// KeyString formats a property value as a key of the path. JSON numbers are
+// float64 and fmt.Sprint writes the large ones in exponent form, 6471010001 must
+// stay 6471010001.
+func KeyString(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// addUpdatedAt adds the updated_at column and its trigger to a master map table,
+// tables imported before the column existed are migrated on their first edit.
+func addUpdatedAt(db execer, table string) error {
+	queries := []string{
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s timestamptz NOT NULL DEFAULT now()", table, updatedAtColumn),
+		`CREATE OR REPLACE FUNCTION master_map_updated_at() RETURNS trigger AS $$
+		BEGIN
+			NEW.updated_at = now();
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql`,
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s", updatedAtColumn, table),
+		fmt.Sprintf("CREATE TRIGGER %s BEFORE UPDATE ON %s FOR EACH ROW EXECUTE PROCEDURE master_map_updated_at()", updatedAtColumn, table),
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("Error adding %s : %s.", updatedAtColumn, err.Error())
+		}
+	}
+	return nil
+}
+
+// featureTable holds what an edit needs to know about a master map.
+type featureTable struct {
+	name         string
+	keyColumn    string
+	keyCategory  string
+	geometryType string
+	srid         int
+	categories   map[string]string
+	hasUpdatedAt bool
+}
+
+// newFeatureTable reads the columns of a master map with GetMasterMapAttributes
+// and resolves the key attribute.
+func (s *PostgreStorage) newFeatureTable(masterMap, attrKey string) (*featureTable, error) {
+	mm, err := s.GetMasterMapByName(masterMap)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not found in the database. %s", masterMap, err.Error())
+	}
+	attrs, err := s.GetMasterMapAttributes(masterMap)
+	if err != nil {
+		return nil, err
+	}
+	table, err := quoteIdentifier(masterMap)
+	if err != nil {
+		return nil, err
+	}
+	t := &featureTable{
+		name:         table,
+		geometryType: geometryTypeName(mm.Category),
+		srid:         mm.Srid,
+		categories:   map[string]string{},
+	}
+	for _, a := range attrs {
+		switch a.Name {
+		case "gid":
+			continue
+		case updatedAtColumn:
+			t.hasUpdatedAt = true
+			continue
+		}
+		t.categories[a.Name] = columnType(a.Category)
+	}
+	for _, column := range []string{attrKey, columnName(attrKey)} {
+		if category, ok := t.categories[column]; ok {
+			t.keyColumn, t.keyCategory = column, category
+		}
+	}
+	if t.keyColumn == "" {
+		return nil, fmt.Errorf("attr_key, Attribute %s is not found in %s.", attrKey, masterMap)
+	}
+	return t, nil
+}
+
+// begin starts the edit transaction, migrating the table when needed.
+func (s *PostgreStorage) begin(t *featureTable) (*sql.Tx, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if !t.hasUpdatedAt {
+		if err := addUpdatedAt(tx, t.name); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return tx, nil
+}
+
+// lockFeatures locks the rows having the key and returns how many there are.
+func lockFeatures(tx *sql.Tx, t *featureTable, key string) (int, error) {
+	column, err := quoteIdentifier(t.keyColumn)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := tx.Query(fmt.Sprintf("SELECT gid FROM %s WHERE %s FOR UPDATE", t.name, keyCondition(column, t.keyCategory, key, 1)), key)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	return n, rows.Err()
+}
+
+// lockKey serializes the transactions writing the key of a master map until the
+// transaction ends. Row locks only cover the rows that exist, so two inserts of a
+// new key would both find it free.
+func lockKey(tx *sql.Tx, t *featureTable, key string) error {
+	val, err := t.keyValue(key)
+	if err != nil {
+		return err
+	}
+	// the key is locked by its value, "01" and "1" are the same integer key
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1), hashtext($2))", t.name, KeyString(val)); err != nil {
+		return fmt.Errorf("Error locking key %s : %s.", key, err.Error())
+	}
+	return nil
+}
+
+// uniqueFeature checks that exactly one feature has the key.
+func uniqueFeature(tx *sql.Tx, t *featureTable, key string) error {
+	n, err := lockFeatures(tx, t, key)
+	if err != nil {
+		return err
+	}
+	switch {
+	case n == 0:
+		return ErrFeatureNotFound
+	case n > 1:
+		return fmt.Errorf("%v features have the key %s, the key is not unique.", n, key)
+	}
+	return nil
+}
+
+// columns checks the properties against the column types and returns the
+// columns and values to write, sorted by column.
+func (t *featureTable) columns(properties map[string]interface{}) ([]string, []interface{}, error) {
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var columns []string
+	var values []interface{}
+	for _, key := range keys {
+		column := columnName(key)
+		category, ok := t.categories[column]
+		if !ok {
+			return nil, nil, fmt.Errorf("Attribute %s is not a column of the master map.", key)
+		}
+		val := properties[key]
+		if val != nil {
+			valType, err := valueType(val)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Attribute %s. %s", key, err.Error())
+			}
+			if widenType(category, valType) != category {
+				return nil, nil, fmt.Errorf("Attribute %s must be %s, got %s.", key, category, valType)
+			}
+		}
+		v, err := columnValue(val, category)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Attribute %s. %s", key, err.Error())
+		}
+		columns = append(columns, column)
+		values = append(values, v)
+	}
+	return columns, values, nil
+}
+
+// geometry checks a geometry against the geometry column and encodes it.
+func (t *featureTable) geometry(g geom.T) (string, error) {
+	g, err := promoteGeometry(g, t.geometryType)
+	if err != nil {
+		return "", err
+	}
+	if found := geometryType(g); t.geometryType != "Geometry" && found != t.geometryType {
+		return "", fmt.Errorf("Geometry must be %s, got %s.", t.geometryType, found)
+	}
+	if g, err = geom.SetSRID(g, t.srid); err != nil {
+		return "", err
+	}
+	return ewkbhex.Encode(g, binary.LittleEndian)
+}
+
+// keyValue converts the key of the path to the type of the key column.
+func (t *featureTable) keyValue(key string) (interface{}, error) {
+	switch t.keyCategory {
+	case typeInteger, typeBigint, typeNumeric:
+		v, err := strconv.ParseFloat(key, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Key %s must be a number.", key)
+		}
+		return v, nil
+	case typeBoolean:
+		v, err := strconv.ParseBool(key)
+		if err != nil {
+			return nil, fmt.Errorf("Key %s must be a boolean.", key)
+		}
+		return v, nil
+	}
+	return key, nil
+}
+
+// InsertMasterMapFeature adds a feature whose attrKey attribute is key, the key
+// must not be used by another feature.
+func (s *PostgreStorage) InsertMasterMapFeature(masterMap, attrKey, key string, feature *geojson.Feature) error {
+	t, err := s.newFeatureTable(masterMap, attrKey)
+	if err != nil {
+		return err
+	}
+	if feature.Geometry == nil {
+		return fmt.Errorf("geometry, A new feature needs a geometry.")
+	}
+	properties := map[string]interface{}{}
+	for k, v := range feature.Properties {
+		properties[k] = v
+	}
+	keyProperty := propertyName(t.keyColumn)
+	keyValue, err := t.keyValue(key)
+	if err != nil {
+		return err
+	}
+	if v, ok := properties[keyProperty]; ok && KeyString(v) != KeyString(keyValue) {
+		return fmt.Errorf("Attribute %s is %s but the key in the path is %s.", keyProperty, KeyString(v), key)
+	}
+	properties[keyProperty] = keyValue
+	columns, values, err := t.columns(properties)
+	if err != nil {
+		return err
+	}
+	geometry, err := t.geometry(feature.Geometry)
+	if err != nil {
+		return err
+	}
+	columns = append(columns, "geom")
+	values = append(values, geometry)
+	ident, err := quoteIdentifiers(columns...)
+	if err != nil {
+		return err
+	}
+	placeholders := make([]string, len(values))
+	for i := range values {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	tx, err := s.begin(t)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := lockKey(tx, t, key); err != nil {
+		return err
+	}
+	n, err := lockFeatures(tx, t, key)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("Feature with %s = %s already exists.", keyProperty, key)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", t.name, strings.Join(ident, ", "), strings.Join(placeholders, ", "))
+	if _, err := tx.Exec(query, values...); err != nil {
+		return fmt.Errorf("Error inserting feature : %s.", err.Error())
+	}
+	return tx.Commit()
+}
+
+// UpdateMasterMapFeature changes the attributes given in the feature properties
+// and the geometry when the feature has one, other attributes are kept.
+func (s *PostgreStorage) UpdateMasterMapFeature(masterMap, attrKey, key string, feature *geojson.Feature) error {
+	t, err := s.newFeatureTable(masterMap, attrKey)
+	if err != nil {
+		return err
+	}
+	columns, values, err := t.columns(feature.Properties)
+	if err != nil {
+		return err
+	}
+	if feature.Geometry != nil {
+		geometry, err := t.geometry(feature.Geometry)
+		if err != nil {
+			return err
+		}
+		columns = append(columns, "geom")
+		values = append(values, geometry)
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("Nothing to update, send properties or a geometry.")
+	}
+	ident, err := quoteIdentifiers(append(columns, t.keyColumn)...)
+	if err != nil {
+		return err
+	}
+	set := make([]string, len(columns))
+	for i := range columns {
+		set[i] = fmt.Sprintf("%s = $%d", ident[i], i+1)
+	}
+
+	tx, err := s.begin(t)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := uniqueFeature(tx, t, key); err != nil {
+		return err
+	}
+	// a new key must not be used by another feature, it is locked like an insert
+	if v, ok := feature.Properties[propertyName(t.keyColumn)]; ok && v != nil {
+		newKey := KeyString(v)
+		oldValue, err := t.keyValue(key)
+		if err != nil {
+			return err
+		}
+		if newKey != KeyString(oldValue) {
+			if err := lockKey(tx, t, newKey); err != nil {
+				return err
+			}
+			n, err := lockFeatures(tx, t, newKey)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				return fmt.Errorf("Feature with %s = %s already exists.", propertyName(t.keyColumn), newKey)
+			}
+		}
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s", t.name, strings.Join(set, ", "), keyCondition(ident[len(ident)-1], t.keyCategory, key, len(values)+1))
+	if _, err := tx.Exec(query, append(values, key)...); err != nil {
+		return fmt.Errorf("Error updating feature : %s.", err.Error())
+	}
+	return tx.Commit()
+}
+
+// DeleteMasterMapFeature removes the feature whose attrKey attribute is key.
+func (s *PostgreStorage) DeleteMasterMapFeature(masterMap, attrKey, key string) error {
+	t, err := s.newFeatureTable(masterMap, attrKey)
+	if err != nil {
+		return err
+	}
+	column, err := quoteIdentifier(t.keyColumn)
+	if err != nil {
+		return err
+	}
+	tx, err := s.begin(t)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := uniqueFeature(tx, t, key); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", t.name, keyCondition(column, t.keyCategory, key, 1)), key); err != nil {
+		return fmt.Errorf("Error deleting feature : %s.", err.Error())
+	}
+	return tx.Commit()
+}
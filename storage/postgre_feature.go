@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nahrx/geomatis-api/types"
+	"github.com/twpayne/go-geom/encoding/geojson"
+	"github.com/twpayne/go-geom/encoding/wkb"
+)
+
+// GetMasterMapSchema describes a stored master map the way an import reports it.
+func (s *PostgreStorage) GetMasterMapSchema(masterMap string) (*types.MasterMapSchema, error) {
+	mm, err := s.GetMasterMapByName(masterMap)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not found in the database. %s", masterMap, err.Error())
+	}
+	schema := &types.MasterMapSchema{
+		Name:         mm.Name,
+		GeometryType: geometryTypeName(mm.Category),
+		Srid:         mm.Srid,
+		Committed:    true,
+	}
+	query, err := s.Db.Query(`
+		SELECT column_name, udt_name, is_nullable = 'YES'
+		FROM INFORMATION_SCHEMA.COLUMNS
+		WHERE table_schema = 'public' AND table_name = $1 AND udt_name != 'geometry' AND column_name != 'gid' AND column_name != $2
+		ORDER BY column_name ASC
+	`, masterMap, updatedAtColumn)
+	if err != nil {
+		return nil, err
+	}
+	defer query.Close()
+	for query.Next() {
+		var c types.MasterMapColumn
+		var udtName string
+		if err := query.Scan(&c.Name, &udtName, &c.Nullable); err != nil {
+			return nil, err
+		}
+		c.Property = propertyName(c.Name)
+		c.Category = columnType(udtName)
+		schema.Columns = append(schema.Columns, c)
+	}
+	if err := query.Err(); err != nil {
+		return nil, err
+	}
+
+	table, err := quoteIdentifier(masterMap)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Db.QueryRow("SELECT count(*) FROM " + table).Scan(&schema.Features); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// EachMasterMapFeature reads the features of a master map ordered by gid and
+// passes them one by one to fn, so a whole layer is never held in memory.
+func (s *PostgreStorage) EachMasterMapFeature(schema *types.MasterMapSchema, fn func(*geojson.Feature) error) error {
+	return s.queryFeatures(schema, &types.FeatureQuery{}, fn)
+}
+
+// GetMasterMapFeatures returns one page of the features matching the query and
+// the number of features matching it in total.
+func (s *PostgreStorage) GetMasterMapFeatures(schema *types.MasterMapSchema, q *types.FeatureQuery) ([]*geojson.Feature, int, error) {
+	table, err := quoteIdentifier(schema.Name)
+	if err != nil {
+		return nil, 0, err
+	}
+	where, args, err := featureFilter(schema, q)
+	if err != nil {
+		return nil, 0, err
+	}
+	var matched int
+	if err := s.Db.QueryRow("SELECT count(*) FROM "+table+where, args...).Scan(&matched); err != nil {
+		return nil, 0, err
+	}
+	features := []*geojson.Feature{}
+	err = s.queryFeatures(schema, q, func(feature *geojson.Feature) error {
+		features = append(features, feature)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return features, matched, nil
+}
+
+// featureFilter builds the WHERE clause of the attribute and bbox filters.
+func featureFilter(schema *types.MasterMapSchema, q *types.FeatureQuery) (string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+	keys := make([]string, 0, len(q.Filters))
+	for key := range q.Filters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		column, category := "", ""
+		for _, c := range schema.Columns {
+			if c.Name == key || c.Property == key {
+				column, category = c.Name, c.Category
+			}
+		}
+		if column == "" {
+			return "", nil, fmt.Errorf("Attribute %s is not found in %s", key, schema.Name)
+		}
+		ident, err := quoteIdentifier(column)
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, q.Filters[key])
+		conditions = append(conditions, keyCondition(ident, category, q.Filters[key], len(args)))
+	}
+	if b := q.BBox; b != nil {
+		// the box is given in the layer CRS unless BBoxSrid says otherwise
+		srid := schema.Srid
+		if q.BBoxSrid != 0 {
+			srid = q.BBoxSrid
+		}
+		args = append(args, b.MinX, b.MinY, b.MaxX, b.MaxY, srid, schema.Srid)
+		n := len(args)
+		conditions = append(conditions, fmt.Sprintf("ST_Intersects(geom, ST_Transform(ST_MakeEnvelope($%d, $%d, $%d, $%d, $%d), $%d::integer))", n-5, n-4, n-3, n-2, n-1, n))
+	}
+	if len(conditions) == 0 {
+		return "", nil, nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+func (s *PostgreStorage) queryFeatures(schema *types.MasterMapSchema, q *types.FeatureQuery, fn func(*geojson.Feature) error) error {
+	names := []string{schema.Name}
+	for _, c := range schema.Columns {
+		names = append(names, c.Name)
+	}
+	ident, err := quoteIdentifiers(names...)
+	if err != nil {
+		return err
+	}
+	where, args, err := featureFilter(schema, q)
+	if err != nil {
+		return err
+	}
+	geometry := "ST_AsBinary(geom)"
+	if q.Srid != 0 && q.Srid != schema.Srid {
+		args = append(args, q.Srid)
+		geometry = fmt.Sprintf("ST_AsBinary(ST_Transform(geom, $%d::integer))", len(args))
+	}
+	selected := append([]string{"gid"}, ident[1:]...)
+	selected = append(selected, geometry)
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY gid", strings.Join(selected, ", "), ident[0], where)
+	if q.Limit > 0 {
+		args = append(args, q.Limit, q.Offset)
+		query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	}
+	rows, err := s.Db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var gid int64
+		var geometry []byte
+		values := make([]interface{}, len(schema.Columns))
+		pointers := []interface{}{&gid}
+		for i := range values {
+			pointers = append(pointers, &values[i])
+		}
+		pointers = append(pointers, &geometry)
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+
+		feature := &geojson.Feature{
+			ID:         strconv.FormatInt(gid, 10),
+			Properties: map[string]interface{}{},
+		}
+		for i, c := range schema.Columns {
+			feature.Properties[c.Property] = propertyValue(values[i], c.Category)
+		}
+		if geometry != nil {
+			if feature.Geometry, err = wkb.Unmarshal(geometry); err != nil {
+				return fmt.Errorf("Failed to decode geometry of feature %d. %s", gid, err.Error())
+			}
+		}
+		if err := fn(feature); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// propertyName undoes columnName.
+func propertyName(column string) string {
+	if column == "__gid" || column == "__geom" || column == "__"+updatedAtColumn {
+		return strings.TrimPrefix(column, "__")
+	}
+	return column
+}
+
+// columnType maps a PostgreSQL udt name to the types of the schema inference.
+func columnType(udtName string) string {
+	switch udtName {
+	case "int2", "int4":
+		return typeInteger
+	case "int8":
+		return typeBigint
+	case "numeric", "float4", "float8":
+		return typeNumeric
+	case "bool":
+		return typeBoolean
+	case "json", "jsonb":
+		return typeJsonb
+	}
+	return typeText
+}
+
+// geometryTypeName turns the upper case type of geometry_columns into the
+// GeoJSON spelling used by the schema.
+func geometryTypeName(category string) string {
+	for _, t := range []string{"Point", "LineString", "Polygon", "MultiPoint", "MultiLineString", "MultiPolygon", "GeometryCollection"} {
+		if strings.EqualFold(t, category) {
+			return t
+		}
+	}
+	return "Geometry"
+}
+
+// propertyValue converts a scanned column back to a JSON friendly value.
+func propertyValue(val interface{}, category string) interface{} {
+	switch v := val.(type) {
+	case []byte:
+		switch category {
+		case typeNumeric:
+			if f, err := strconv.ParseFloat(string(v), 64); err == nil {
+				return f
+			}
+		case typeJsonb:
+			return json.RawMessage(v)
+		}
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return val
+}
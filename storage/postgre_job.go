@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nahrx/geomatis-api/types"
+)
+
+// jobTable keeps georeference jobs so queued and running jobs survive a restart.
+const jobTable = "geomatis_jobs"
+
+// jobFileTable keeps the outcome of every raster of a job in its own row, so a
+// finished raster rewrites one row instead of the whole file list. The files
+// column of jobTable is only read for jobs stored before this table existed.
+const jobFileTable = "geomatis_job_files"
+
+func (s *PostgreStorage) createJobTable() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS ` + jobTable + ` (
+			id varchar(36) primary key,
+			status varchar(16) not null,
+			dir_path text not null,
+			total integer not null default 0,
+			success integer not null default 0,
+			fail integer not null default 0,
+			error text not null default '',
+			files jsonb not null default '[]',
+			params jsonb not null default '{}',
+			reports jsonb not null default '[]',
+			created_at timestamptz not null default now(),
+			updated_at timestamptz not null default now()
+		);
+		CREATE TABLE IF NOT EXISTS ` + jobFileTable + ` (
+			job_id varchar(36) not null references ` + jobTable + ` (id) on delete cascade,
+			position integer not null,
+			result jsonb not null,
+			primary key (job_id, position)
+		);
+	`
+	_, err := s.Db.Exec(query)
+	return err
+}
+
+// setJobFiles replaces the file rows of a job with job.Files in one statement.
+func setJobFiles(tx *sql.Tx, job *types.Job) error {
+	files, err := json.Marshal(job.Files)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM "+jobFileTable+" WHERE job_id = $1", job.Id); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO `+jobFileTable+` (job_id, position, result)
+		SELECT $1, f.position - 1, f.result FROM jsonb_array_elements($2::jsonb) WITH ORDINALITY AS f(result, position)
+	`, job.Id, files)
+	return err
+}
+
+func (s *PostgreStorage) CreateJob(job *types.Job) error {
+	params, err := json.Marshal(job.Params)
+	if err != nil {
+		return err
+	}
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `
+		INSERT INTO ` + jobTable + ` (id, status, dir_path, total, success, fail, error, params)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING created_at, updated_at
+	`
+	err = tx.QueryRow(query, job.Id, job.Status, job.DirPath, job.Total, job.Success, job.Fail, job.Err, params).Scan(&job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("Failed to create job %s. Error :%s", job.Id, err.Error())
+	}
+	if err := setJobFiles(tx, job); err != nil {
+		return fmt.Errorf("Failed to create files of job %s. Error :%s", job.Id, err.Error())
+	}
+	return tx.Commit()
+}
+
+// UpdateJob writes the status, counters and reports of a job, the files are
+// written by UpdateJobFile and RestartJob.
+func (s *PostgreStorage) UpdateJob(job *types.Job) error {
+	return updateJob(s.Db, job)
+}
+
+func updateJob(db queryRower, job *types.Job) error {
+	reports, err := json.Marshal(job.Reports)
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE ` + jobTable + `
+		SET status = $2, total = $3, success = $4, fail = $5, error = $6, reports = $7, updated_at = now()
+		WHERE id = $1
+		RETURNING updated_at
+	`
+	err = db.QueryRow(query, job.Id, job.Status, job.Total, job.Success, job.Fail, job.Err, reports).Scan(&job.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("Failed to update job %s. Error :%s", job.Id, err.Error())
+	}
+	return nil
+}
+
+// UpdateJobFile writes the outcome of file index with the job counters.
+func (s *PostgreStorage) UpdateJobFile(job *types.Job, index int) error {
+	result, err := json.Marshal(job.Files[index])
+	if err != nil {
+		return err
+	}
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+		INSERT INTO `+jobFileTable+` (job_id, position, result) VALUES ($1, $2, $3)
+		ON CONFLICT (job_id, position) DO UPDATE SET result = EXCLUDED.result
+	`, job.Id, index, result)
+	if err != nil {
+		return fmt.Errorf("Failed to update file %d of job %s. Error :%s", index, job.Id, err.Error())
+	}
+	if err := updateJob(tx, job); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RestartJob writes the job and replaces all of its files, a resumed job starts over.
+func (s *PostgreStorage) RestartJob(job *types.Job) error {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := updateJob(tx, job); err != nil {
+		return err
+	}
+	if err := setJobFiles(tx, job); err != nil {
+		return fmt.Errorf("Failed to reset files of job %s. Error :%s", job.Id, err.Error())
+	}
+	return tx.Commit()
+}
+
+func (s *PostgreStorage) GetJob(id string) (*types.Job, error) {
+	query := `
+		SELECT id, status, dir_path, total, success, fail, error, files, reports, params, created_at, updated_at
+		FROM ` + jobTable + `
+		WHERE id = $1
+	`
+	var job types.Job
+	var files, reports, params []byte
+	err := s.Db.QueryRow(query, id).Scan(&job.Id, &job.Status, &job.DirPath, &job.Total, &job.Success, &job.Fail, &job.Err, &files, &reports, &params, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch job %s. Error :%s", id, err.Error())
+	}
+	rows, err := s.Db.Query("SELECT result FROM "+jobFileTable+" WHERE job_id = $1 ORDER BY position", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var result []byte
+		if err := rows.Scan(&result); err != nil {
+			return nil, err
+		}
+		var f types.Result
+		if err := json.Unmarshal(result, &f); err != nil {
+			return nil, err
+		}
+		job.Files = append(job.Files, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// jobs stored before the file table keep their files in the job row
+	if len(job.Files) == 0 {
+		if err := json.Unmarshal(files, &job.Files); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(reports, &job.Reports); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(params, &job.Params); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetJobs lists every job without the per file outcomes, newest first.
+func (s *PostgreStorage) GetJobs() ([]types.Job, error) {
+	query, err := s.Db.Query(`
+		SELECT id, status, dir_path, total, success, fail, error, reports, params, created_at, updated_at
+		FROM ` + jobTable + `
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer query.Close()
+	var values []types.Job
+	for query.Next() {
+		var v types.Job
+		var reports, params []byte
+		err := query.Scan(&v.Id, &v.Status, &v.DirPath, &v.Total, &v.Success, &v.Fail, &v.Err, &reports, &params, &v.CreatedAt, &v.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(reports, &v.Reports); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(params, &v.Params); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	if err := query.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuoteIdentifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", in: "idsls", want: `"idsls"`},
+		{name: "upper case and spaces", in: "Kode SLS", want: `"Kode SLS"`},
+		{name: "embedded double quote", in: `a"b`, want: `"a""b"`},
+		{name: "embedded apostrophe", in: "jum'at", want: `"jum'at"`},
+		{name: "sql in a name", in: `x"; DROP TABLE t; --`, want: `"x""; DROP TABLE t; --"`},
+		{name: "non ascii", in: "kecamatan_é", want: `"kecamatan_é"`},
+		{name: "63 bytes", in: strings.Repeat("a", 63), want: `"` + strings.Repeat("a", 63) + `"`},
+		{name: "empty", in: "", wantErr: true},
+		{name: "64 bytes", in: strings.Repeat("a", 64), wantErr: true},
+		{name: "multibyte over 63 bytes", in: strings.Repeat("é", 32), wantErr: true},
+		{name: "nul", in: "a\x00b", wantErr: true},
+		{name: "newline", in: "a\nb", wantErr: true},
+		{name: "tab", in: "a\tb", wantErr: true},
+		{name: "delete", in: "a\x7fb", wantErr: true},
+		{name: "invalid utf-8", in: "a\xffb", wantErr: true},
+		{name: "truncated utf-8", in: "a\xc3", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := quoteIdentifier(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("quoteIdentifier(%q) = %s, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("quoteIdentifier(%q) : %s", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("quoteIdentifier(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuoteIdentifiers(t *testing.T) {
+	got, err := quoteIdentifiers("a", `b"c`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != `"a"` || got[1] != `"b""c"` {
+		t.Errorf("quoteIdentifiers = %v", got)
+	}
+	if _, err := quoteIdentifiers("a", ""); err == nil {
+		t.Error("an empty name among others must fail")
+	}
+}
+
+func TestKeyCondition(t *testing.T) {
+	tests := []struct {
+		category string
+		key      string
+		want     string
+	}{
+		{typeText, "6471010001", `"k"::text = $1`},
+		{typeInteger, "42", `"k" = $1::integer`},
+		{typeInteger, "-7", `"k" = $1::integer`},
+		{typeInteger, "3000000000", `"k"::text = $1`},
+		{typeInteger, "abc", `"k"::text = $1`},
+		{typeBigint, "6471010001000100", `"k" = $1::bigint`},
+		{typeBigint, "12.5", `"k"::text = $1`},
+		{typeNumeric, "12.5", `"k" = $1::numeric`},
+		{typeNumeric, "1e3", `"k" = $1::numeric`},
+		{typeNumeric, "x", `"k"::text = $1`},
+		{typeNumeric, "-.5", `"k" = $1::numeric`},
+		{typeNumeric, "Inf", `"k"::text = $1`},
+		{typeNumeric, "NaN", `"k"::text = $1`},
+		{typeNumeric, "0x1p3", `"k"::text = $1`},
+		{typeNumeric, "1_000", `"k"::text = $1`},
+		{typeBoolean, "true", `"k" = $1::boolean`},
+		{typeBoolean, "ya", `"k"::text = $1`},
+		{typeJsonb, "1", `"k"::text = $1`},
+	}
+	for _, tt := range tests {
+		if got := keyCondition(`"k"`, tt.category, tt.key, 1); got != tt.want {
+			t.Errorf("keyCondition(%s, %q) = %s, want %s", tt.category, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestKeyString(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{6471010001.0, "6471010001"},
+		{1e6, "1000000"},
+		{12.5, "12.5"},
+		{-3.0, "-3"},
+		{"6471010001", "6471010001"},
+		{true, "true"},
+	}
+	for _, tt := range tests {
+		if got := KeyString(tt.in); got != tt.want {
+			t.Errorf("KeyString(%v) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
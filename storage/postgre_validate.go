@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/nahrx/geomatis-api/types"
+)
+
+// validationSample is the number of features listed per kind of problem.
+const validationSample = 100
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// ValidateMasterMap checks that the key attribute identifies every feature of a
+// master map and that the geometries can be georeferenced.
+func (s *PostgreStorage) ValidateMasterMap(masterMap, attrKey string) (*types.MasterMapValidation, error) {
+	exist, err := s.MasterMapAttributeExist(masterMap, attrKey)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, fmt.Errorf("%s is not found in %s.", attrKey, masterMap)
+	}
+	return validateMasterMap(s.Db, masterMap, attrKey)
+}
+
+// CountMasterMapFeatures returns the number of features whose attrKey is key.
+func (s *PostgreStorage) CountMasterMapFeatures(masterMap, attrKey, key string) (int, error) {
+	ident, err := quoteIdentifiers(masterMap, attrKey)
+	if err != nil {
+		return 0, err
+	}
+	category, err := s.keyColumnCategory(masterMap, attrKey)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	err = s.Db.QueryRow(fmt.Sprintf("SELECT count(*) FROM %s WHERE %s", ident[0], keyCondition(ident[1], category, key, 1)), key).Scan(&n)
+	return n, err
+}
+
+func validateMasterMap(db queryer, table, attrKey string) (*types.MasterMapValidation, error) {
+	ident, err := quoteIdentifiers(table, attrKey)
+	if err != nil {
+		return nil, err
+	}
+	t, key := ident[0], ident[1]
+	v := &types.MasterMapValidation{MasterMap: table, Key: attrKey, DuplicateKeys: []types.DuplicateKey{}}
+	if err := db.QueryRow("SELECT count(*) FROM " + t).Scan(&v.Features); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT k, n, gids, count(*) OVER ()
+		FROM (
+			SELECT %s::text AS k, count(*) AS n, array_agg(gid ORDER BY gid) AS gids
+			FROM %s
+			WHERE %s IS NOT NULL
+			GROUP BY 1
+			HAVING count(*) > 1
+		) AS duplicates
+		ORDER BY n DESC, k
+		LIMIT %d
+	`, key, t, key, validationSample))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d types.DuplicateKey
+		var gids pq.Int64Array
+		if err := rows.Scan(&d.Key, &d.Count, &gids, &v.DuplicateCount); err != nil {
+			return nil, err
+		}
+		for _, gid := range gids {
+			d.Gids = append(d.Gids, int(gid))
+		}
+		v.DuplicateKeys = append(v.DuplicateKeys, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	checks := []struct {
+		issues *types.ValidationIssues
+		where  string
+		reason string
+	}{
+		{&v.NullKeys, fmt.Sprintf("%s IS NULL OR %s::text = ''", key, key), "''"},
+		{&v.EmptyGeometries, "geom IS NULL OR ST_IsEmpty(geom)", "''"},
+		{&v.InvalidGeometries, "geom IS NOT NULL AND NOT ST_IsEmpty(geom) AND NOT ST_IsValid(geom)", "ST_IsValidReason(geom)"},
+		{&v.MultipartFeatures, "geom IS NOT NULL AND ST_NumGeometries(geom) > 1", "ST_NumGeometries(geom) || ' parts'"},
+	}
+	for _, c := range checks {
+		if err := featureIssues(db, c.issues, fmt.Sprintf(`
+			SELECT gid, COALESCE(%s::text, ''), %s, count(*) OVER ()
+			FROM %s
+			WHERE %s
+			ORDER BY gid
+			LIMIT %d
+		`, key, c.reason, t, c.where, validationSample)); err != nil {
+			return nil, err
+		}
+	}
+	v.Valid = v.DuplicateCount == 0 && v.NullKeys.Count == 0 && v.EmptyGeometries.Count == 0 && v.InvalidGeometries.Count == 0
+	return v, nil
+}
+
+func featureIssues(db queryer, issues *types.ValidationIssues, query string) error {
+	issues.Features = []types.FeatureIssue{}
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f types.FeatureIssue
+		if err := rows.Scan(&f.Gid, &f.Key, &f.Reason, &issues.Count); err != nil {
+			return err
+		}
+		issues.Features = append(issues.Features, f)
+	}
+	return rows.Err()
+}
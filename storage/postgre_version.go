@@ -0,0 +1,337 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+
+	"github.com/nahrx/geomatis-api/types"
+)
+
+// versionTable lists the versions of every master map. The active version is
+// stored in the table named after the master map, the others in {name}__{version}.
+const versionTable = "geomatis_master_map_versions"
+
+// archivedVersionQuery finds the versions row of g.f_table_name when it is the
+// table of a version that is not active.
+const archivedVersionQuery = `
+	SELECT 1 FROM ` + versionTable + ` a
+	WHERE NOT a.active AND a.master_map || '__' || a.version = g.f_table_name::text`
+
+// legacyVersion is given to master maps imported before versioning.
+const legacyVersion = "0"
+
+// defaultVersion is the version of a first import without a version field.
+const defaultVersion = "1"
+
+var validVersion = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,32}$`)
+
+func (s *PostgreStorage) createVersionTable() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS ` + versionTable + ` (
+			master_map text not null,
+			version text not null,
+			active boolean not null default false,
+			features integer not null default 0,
+			srid integer not null default 0,
+			created_at timestamptz not null default now(),
+			activated_at timestamptz,
+			primary key (master_map, version)
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS ` + versionTable + `_active ON ` + versionTable + ` (master_map) WHERE active;
+	`
+	_, err := s.Db.Exec(query)
+	return err
+}
+
+// versionTableName is the table of a version that is not active.
+func versionTableName(masterMap, version string) string {
+	return masterMap + "__" + version
+}
+
+func checkVersion(version string) error {
+	if !validVersion.MatchString(version) {
+		return fmt.Errorf("version, Version %q must be 1 to 32 letters, digits, '_', '.' or '-'.", version)
+	}
+	return nil
+}
+
+// registerLegacyVersion records a master map imported before versioning as the
+// active version 0, so it can be rolled back to once a new version is activated.
+func registerLegacyVersion(tx *sql.Tx, masterMap string) error {
+	var registered bool
+	err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM "+versionTable+" WHERE master_map = $1)", masterMap).Scan(&registered)
+	if err != nil || registered {
+		return err
+	}
+	table, err := quoteIdentifier(masterMap)
+	if err != nil {
+		return err
+	}
+	var features, srid int
+	if err := tx.QueryRow("SELECT count(*) FROM " + table).Scan(&features); err != nil {
+		return err
+	}
+	err = tx.QueryRow(`
+		SELECT srid FROM geometry_columns WHERE f_table_schema = 'public' AND f_table_name = $1
+	`, masterMap).Scan(&srid)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO `+versionTable+` (master_map, version, active, features, srid, activated_at)
+		VALUES ($1, $2, true, $3, $4, now())
+	`, masterMap, legacyVersion, features, srid)
+	return err
+}
+
+func (s *PostgreStorage) versionExist(masterMap, version string) (bool, error) {
+	var exists bool
+	err := s.Db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM `+versionTable+` WHERE master_map = $1 AND version = $2)
+	`, masterMap, version).Scan(&exists)
+	// master maps imported before versioning only have version 0
+	if err == nil && !exists && version == legacyVersion {
+		err = s.Db.QueryRow(`
+			SELECT NOT EXISTS (SELECT 1 FROM `+versionTable+` WHERE master_map = $1)
+		`, masterMap).Scan(&exists)
+	}
+	return exists, err
+}
+
+// registerVersion records an import. A first import is the active version, a
+// new version of an existing master map is only activated with settings.Activate.
+func registerVersion(tx *sql.Tx, settings *types.MasterMapImportSettings, version string, schema *types.MasterMapSchema) error {
+	if schema.Name == settings.Name {
+		_, err := tx.Exec(`
+			INSERT INTO `+versionTable+` (master_map, version, active, features, srid, activated_at)
+			VALUES ($1, $2, true, $3, $4, now())
+		`, settings.Name, version, schema.Features, schema.Srid)
+		if err != nil {
+			return err
+		}
+		schema.Version, schema.Active = version, true
+		return nil
+	}
+	if err := registerLegacyVersion(tx, settings.Name); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO `+versionTable+` (master_map, version, features, srid)
+		VALUES ($1, $2, $3, $4)
+	`, settings.Name, version, schema.Features, schema.Srid)
+	if err != nil {
+		return fmt.Errorf("Error registering version %s : %s.", version, err.Error())
+	}
+	schema.Version = version
+	if settings.Activate {
+		if err := activateVersion(tx, settings.Name, version); err != nil {
+			return err
+		}
+		schema.Name, schema.Active = settings.Name, true
+	}
+	return nil
+}
+
+// lockVersions locks the versions of a master map for an activation.
+func lockVersions(tx *sql.Tx, masterMap string) ([]types.MasterMapVersion, error) {
+	query, err := tx.Query(`
+		SELECT version, active, features, srid, created_at, activated_at
+		FROM `+versionTable+`
+		WHERE master_map = $1
+		ORDER BY created_at ASC
+		FOR UPDATE
+	`, masterMap)
+	if err != nil {
+		return nil, err
+	}
+	return scanVersions(query, masterMap)
+}
+
+func scanVersions(query *sql.Rows, masterMap string) ([]types.MasterMapVersion, error) {
+	defer query.Close()
+	var values []types.MasterMapVersion
+	for query.Next() {
+		v := types.MasterMapVersion{MasterMap: masterMap}
+		if err := query.Scan(&v.Version, &v.Active, &v.Features, &v.Srid, &v.CreatedAt, &v.ActivatedAt); err != nil {
+			return nil, err
+		}
+		v.Table = masterMap
+		if !v.Active {
+			v.Table = versionTableName(masterMap, v.Version)
+		}
+		values = append(values, v)
+	}
+	return values, query.Err()
+}
+
+// activateVersion swaps the tables of the active version and the given one.
+func activateVersion(tx *sql.Tx, masterMap, version string) error {
+	versions, err := lockVersions(tx, masterMap)
+	if err != nil {
+		return err
+	}
+	var active, target *types.MasterMapVersion
+	for i := range versions {
+		if versions[i].Active {
+			active = &versions[i]
+		}
+		if versions[i].Version == version {
+			target = &versions[i]
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("Version %s of %s is not found.", version, masterMap)
+	}
+	if target.Active {
+		return fmt.Errorf("Version %s of %s is already active.", version, masterMap)
+	}
+	names := []string{masterMap, target.Table}
+	if active != nil {
+		names = append(names, versionTableName(masterMap, active.Version))
+	}
+	ident, err := quoteIdentifiers(names...)
+	if err != nil {
+		return err
+	}
+	if active != nil {
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", ident[0], ident[2])); err != nil {
+			return fmt.Errorf("Error archiving version %s : %s.", active.Version, err.Error())
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", ident[1], ident[0])); err != nil {
+		return fmt.Errorf("Error activating version %s : %s.", version, err.Error())
+	}
+	_, err = tx.Exec(`
+		UPDATE `+versionTable+`
+		SET active = (version = $2), activated_at = CASE WHEN version = $2 THEN now() ELSE activated_at END
+		WHERE master_map = $1
+	`, masterMap, version)
+	return err
+}
+
+// GetMasterMapVersions lists the versions of a master map, oldest first. A
+// master map imported before versioning is listed as the active version 0, it
+// is only registered once a version is added or activated.
+func (s *PostgreStorage) GetMasterMapVersions(masterMap string) ([]types.MasterMapVersion, error) {
+	exist, err := s.MasterMapExist(masterMap)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, fmt.Errorf("%s is not found in the database.", masterMap)
+	}
+	query, err := s.Db.Query(`
+		SELECT version, active, features, srid, created_at, activated_at
+		FROM `+versionTable+`
+		WHERE master_map = $1
+		ORDER BY created_at ASC
+	`, masterMap)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := scanVersions(query, masterMap)
+	if err != nil || len(versions) > 0 {
+		return versions, err
+	}
+
+	table, err := quoteIdentifier(masterMap)
+	if err != nil {
+		return nil, err
+	}
+	legacy := types.MasterMapVersion{MasterMap: masterMap, Version: legacyVersion, Table: masterMap, Active: true}
+	err = s.Db.QueryRow(`
+		SELECT (SELECT count(*) FROM `+table+`), srid
+		FROM geometry_columns WHERE f_table_schema = 'public' AND f_table_name = $1
+	`, masterMap).Scan(&legacy.Features, &legacy.Srid)
+	if err != nil {
+		return nil, err
+	}
+	return []types.MasterMapVersion{legacy}, nil
+}
+
+// ActivateMasterMapVersion makes version the one used by georeference and the
+// feature endpoints.
+func (s *PostgreStorage) ActivateMasterMapVersion(masterMap, version string) error {
+	return s.versionTx(masterMap, func(tx *sql.Tx) error {
+		return activateVersion(tx, masterMap, version)
+	})
+}
+
+// RollbackMasterMap activates the version that was active before the current
+// one and returns it.
+func (s *PostgreStorage) RollbackMasterMap(masterMap string) (string, error) {
+	var previous string
+	err := s.versionTx(masterMap, func(tx *sql.Tx) error {
+		versions, err := lockVersions(tx, masterMap)
+		if err != nil {
+			return err
+		}
+		var last *types.MasterMapVersion
+		for i, v := range versions {
+			if v.Active || v.ActivatedAt == nil {
+				continue
+			}
+			if last == nil || v.ActivatedAt.After(*last.ActivatedAt) {
+				last = &versions[i]
+			}
+		}
+		if last == nil {
+			return fmt.Errorf("%s has no previous version to roll back to.", masterMap)
+		}
+		previous = last.Version
+		return activateVersion(tx, masterMap, previous)
+	})
+	return previous, err
+}
+
+// DeleteMasterMapVersion drops a version that is not active.
+func (s *PostgreStorage) DeleteMasterMapVersion(masterMap, version string) error {
+	return s.versionTx(masterMap, func(tx *sql.Tx) error {
+		versions, err := lockVersions(tx, masterMap)
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			if v.Version != version {
+				continue
+			}
+			if v.Active {
+				return fmt.Errorf("Version %s of %s is active, activate another version before deleting it.", version, masterMap)
+			}
+			table, err := quoteIdentifier(v.Table)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+				return err
+			}
+			_, err = tx.Exec("DELETE FROM "+versionTable+" WHERE master_map = $1 AND version = $2", masterMap, version)
+			return err
+		}
+		return fmt.Errorf("Version %s of %s is not found.", version, masterMap)
+	})
+}
+
+// versionTx runs fn in a transaction after registering a legacy master map.
+func (s *PostgreStorage) versionTx(masterMap string, fn func(tx *sql.Tx) error) error {
+	exist, err := s.MasterMapExist(masterMap)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return fmt.Errorf("%s is not found in the database.", masterMap)
+	}
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := registerLegacyVersion(tx, masterMap); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
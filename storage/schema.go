@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nahrx/geomatis-api/types"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+// column types, ordered from the narrowest number type to the widest
+const (
+	typeBoolean = "boolean"
+	typeInteger = "integer"
+	typeBigint  = "bigint"
+	typeNumeric = "numeric"
+	typeText    = "text"
+	typeJsonb   = "jsonb"
+)
+
+// progressInterval is the number of features between two progress reports.
+const progressInterval = 1000
+
+var numberRank = map[string]int{typeInteger: 1, typeBigint: 2, typeNumeric: 3}
+
+// inferSchema scans every feature, unions the property keys and widens the column
+// types when the values conflict. The geometry type is promoted to its multi type
+// when single and multi geometries are mixed.
+func inferSchema(name string, source FeatureSource, progress func(int)) (*types.MasterMapSchema, error) {
+	schema := &types.MasterMapSchema{Name: name}
+	columns := map[string]*types.MasterMapColumn{}
+	present := map[string]int{}
+	geometryTypes := map[string]bool{}
+	err := source(func(feature *geojson.Feature) error {
+		i := schema.Features
+		schema.Features++
+		if progress != nil && schema.Features%progressInterval == 0 {
+			progress(schema.Features)
+		}
+		if feature.Geometry != nil {
+			geometryTypes[geometryType(feature.Geometry)] = true
+		}
+		for key, val := range feature.Properties {
+			column, ok := columns[key]
+			if !ok {
+				column = &types.MasterMapColumn{Name: columnName(key), Property: key}
+				columns[key] = column
+			}
+			if val == nil {
+				continue
+			}
+			present[key]++
+			t, err := valueType(val)
+			if err != nil {
+				return fmt.Errorf("Property %s of feature %d. %s", key, i, err.Error())
+			}
+			column.Category = widenType(column.Category, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{}
+	for key, column := range columns {
+		if other, ok := names[column.Name]; ok {
+			return nil, fmt.Errorf("Properties %s and %s are both stored as column %s", other, key, column.Name)
+		}
+		names[column.Name] = key
+		if column.Category == "" {
+			// only null values, nothing to infer from
+			column.Category = typeText
+		}
+		column.Nullable = present[key] < schema.Features
+		schema.Columns = append(schema.Columns, *column)
+	}
+	sort.Slice(schema.Columns, func(i, j int) bool {
+		return schema.Columns[i].Name < schema.Columns[j].Name
+	})
+	schema.GeometryType = unionGeometryType(geometryTypes)
+	return schema, nil
+}
+
+// valueType returns the narrowest column type holding a decoded JSON value.
+func valueType(val interface{}) (string, error) {
+	switch v := val.(type) {
+	case bool:
+		return typeBoolean, nil
+	case float64:
+		if v != math.Trunc(v) || math.IsInf(v, 0) {
+			return typeNumeric, nil
+		}
+		if v >= math.MinInt32 && v <= math.MaxInt32 {
+			return typeInteger, nil
+		}
+		// beyond 2^53 the decoded float is no longer an exact integer
+		if math.Abs(v) <= 1<<53 {
+			return typeBigint, nil
+		}
+		return typeNumeric, nil
+	case int64:
+		// integers read from a GeoPackage
+		if v >= math.MinInt32 && v <= math.MaxInt32 {
+			return typeInteger, nil
+		}
+		return typeBigint, nil
+	case string:
+		return typeText, nil
+	case map[string]interface{}, []interface{}:
+		return typeJsonb, nil
+	}
+	return "", fmt.Errorf("Unsupported value type %T", val)
+}
+
+// widenType returns a type holding the values of both a and b.
+func widenType(a, b string) string {
+	switch {
+	case a == "" || a == b:
+		return b
+	case a == typeJsonb || b == typeJsonb:
+		// any JSON value can be kept as jsonb
+		return typeJsonb
+	}
+	rankA, numberA := numberRank[a]
+	rankB, numberB := numberRank[b]
+	if numberA && numberB {
+		if rankA > rankB {
+			return a
+		}
+		return b
+	}
+	return typeText
+}
+
+func geometryType(g geom.T) string {
+	switch g.(type) {
+	case *geom.Point:
+		return "Point"
+	case *geom.LineString:
+		return "LineString"
+	case *geom.Polygon:
+		return "Polygon"
+	case *geom.MultiPoint:
+		return "MultiPoint"
+	case *geom.MultiLineString:
+		return "MultiLineString"
+	case *geom.MultiPolygon:
+		return "MultiPolygon"
+	case *geom.GeometryCollection:
+		return "GeometryCollection"
+	}
+	return "Geometry"
+}
+
+// unionGeometryType gives one PostGIS geometry type for all geometry types found.
+func unionGeometryType(found map[string]bool) string {
+	if len(found) == 1 {
+		for t := range found {
+			return t
+		}
+	}
+	if len(found) == 2 {
+		for t := range found {
+			if multi := "Multi" + t; found[multi] {
+				return multi
+			}
+		}
+	}
+	return "Geometry"
+}
+
+// promoteGeometry wraps a single geometry when the column holds its multi type.
+func promoteGeometry(g geom.T, geometryType string) (geom.T, error) {
+	if !strings.HasPrefix(geometryType, "Multi") {
+		return g, nil
+	}
+	switch v := g.(type) {
+	case *geom.Point:
+		multi := geom.NewMultiPoint(v.Layout())
+		return multi, multi.Push(v)
+	case *geom.LineString:
+		multi := geom.NewMultiLineString(v.Layout())
+		return multi, multi.Push(v)
+	case *geom.Polygon:
+		multi := geom.NewMultiPolygon(v.Layout())
+		return multi, multi.Push(v)
+	}
+	return g, nil
+}
+
+// columnValue converts a decoded JSON value to what COPY expects for the column type.
+func columnValue(val interface{}, columnType string) (interface{}, error) {
+	if val == nil {
+		return nil, nil
+	}
+	switch columnType {
+	case typeInteger, typeBigint:
+		if v, ok := val.(int64); ok {
+			return v, nil
+		}
+		return int64(val.(float64)), nil
+	case typeJsonb:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case typeText:
+		switch v := val.(type) {
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+	}
+	return val, nil
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"math"
+	"testing"
+
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+func TestValueType(t *testing.T) {
+	tests := []struct {
+		name    string
+		val     interface{}
+		want    string
+		wantErr bool
+	}{
+		{name: "bool", val: true, want: typeBoolean},
+		{name: "zero", val: 0.0, want: typeInteger},
+		{name: "max int32", val: float64(math.MaxInt32), want: typeInteger},
+		{name: "min int32", val: float64(math.MinInt32), want: typeInteger},
+		{name: "above int32", val: float64(math.MaxInt32) + 1, want: typeBigint},
+		{name: "below int32", val: float64(math.MinInt32) - 1, want: typeBigint},
+		{name: "2^53", val: float64(1 << 53), want: typeBigint},
+		{name: "above 2^53", val: float64(1<<53) * 4, want: typeNumeric},
+		{name: "fraction", val: 1.5, want: typeNumeric},
+		{name: "infinity", val: math.Inf(1), want: typeNumeric},
+		{name: "string", val: "6471010001", want: typeText},
+		{name: "object", val: map[string]interface{}{"a": 1.0}, want: typeJsonb},
+		{name: "array", val: []interface{}{1.0}, want: typeJsonb},
+		{name: "int64", val: int64(1), want: typeInteger},
+		{name: "int64 above int32", val: int64(6471010001000123), want: typeBigint},
+		{name: "unsupported", val: int32(1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := valueType(tt.val)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("valueType(%v) = %s, want an error", tt.val, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("valueType(%v) = %s, want %s", tt.val, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWidenType(t *testing.T) {
+	tests := []struct {
+		a, b, want string
+	}{
+		{"", typeInteger, typeInteger},
+		{typeInteger, typeInteger, typeInteger},
+		{typeInteger, typeBigint, typeBigint},
+		{typeBigint, typeInteger, typeBigint},
+		{typeInteger, typeNumeric, typeNumeric},
+		{typeNumeric, typeBigint, typeNumeric},
+		{typeInteger, typeText, typeText},
+		{typeText, typeNumeric, typeText},
+		{typeBoolean, typeInteger, typeText},
+		{typeBoolean, typeText, typeText},
+		{typeText, typeJsonb, typeJsonb},
+		{typeJsonb, typeInteger, typeJsonb},
+	}
+	for _, tt := range tests {
+		if got := widenType(tt.a, tt.b); got != tt.want {
+			t.Errorf("widenType(%q, %q) = %s, want %s", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestInferSchema(t *testing.T) {
+	polygon := geom.NewPolygon(geom.XY).MustSetCoords([][]geom.Coord{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}})
+	multi := geom.NewMultiPolygon(geom.XY)
+	multi.Push(polygon)
+	features := []*geojson.Feature{
+		{Geometry: polygon, Properties: map[string]interface{}{"idsls": "01", "n": 1.0, "gid": 7.0}},
+		{Geometry: multi, Properties: map[string]interface{}{"idsls": "02", "n": 2.5, "note": nil}},
+	}
+	source := func(fn func(*geojson.Feature) error) error {
+		for _, f := range features {
+			if err := fn(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	schema, err := inferSchema("sls", source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema.Features != 2 || schema.GeometryType != "MultiPolygon" {
+		t.Errorf("features %v, geometry %s", schema.Features, schema.GeometryType)
+	}
+	want := map[string]struct {
+		category string
+		nullable bool
+	}{
+		"__gid": {typeInteger, true},
+		"idsls": {typeText, false},
+		"n":     {typeNumeric, false},
+		"note":  {typeText, true},
+	}
+	if len(schema.Columns) != len(want) {
+		t.Fatalf("columns %v", schema.Columns)
+	}
+	for _, c := range schema.Columns {
+		w, ok := want[c.Name]
+		if !ok || c.Category != w.category || c.Nullable != w.nullable {
+			t.Errorf("column %+v, want %+v", c, w)
+		}
+	}
+}
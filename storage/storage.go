@@ -1,16 +1,45 @@
-package storage
-
-import "github.com/nahrx/geomatis-api/types"
-
-type Storage interface {
-	TableExist(string) (bool, error)
-	MasterMapExist(string) (bool, error)
-	MasterMapAttributeExist(string, string) (bool, error)
-	GetMasterMaps() ([]types.MasterMap, error)
-	GetMasterMapByName(string) (types.MasterMap, error)
-	GetMasterMapAttributes(string) ([]types.MasterMapAttr, error)
-	GetExtent(string, string) (*types.Extent, error)
-	GetAttributesValue(string, string, string, []string) ([]string, error)
-	CreateMasterMaps(string, *[]byte) error
-	DeleteMasterMap(string) error
-}
+package storage
+
+import (
+	"github.com/nahrx/geomatis-api/types"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+// FeatureSource calls fn for every feature of a layer. It is called once to
+// infer the schema and once to copy the features, so a layer can be streamed
+// from a file instead of being held in memory.
+type FeatureSource func(fn func(*geojson.Feature) error) error
+
+type Storage interface {
+	TableExist(string) (bool, error)
+	MasterMapExist(string) (bool, error)
+	MasterMapAttributeExist(string, string) (bool, error)
+	GetMasterMaps() ([]types.MasterMap, error)
+	GetMasterMapByName(string) (types.MasterMap, error)
+	GetMasterMapAttributes(string) ([]types.MasterMapAttr, error)
+	GetExtent(string, string, string, int) (*types.Extent, string, error)
+	GetFeatureGeometry(string, string, string, int) (geom.T, error)
+	GetSpatialReference(int) (string, error)
+	GetAttributesValue(string, string, string, []string) ([]string, error)
+	CreateMasterMaps(*types.MasterMapImportSettings, FeatureSource) (*types.MasterMapSchema, error)
+	DeleteMasterMap(string) error
+	GetMasterMapSchema(string) (*types.MasterMapSchema, error)
+	EachMasterMapFeature(*types.MasterMapSchema, func(*geojson.Feature) error) error
+	GetMasterMapFeatures(*types.MasterMapSchema, *types.FeatureQuery) ([]*geojson.Feature, int, error)
+	InsertMasterMapFeature(string, string, string, *geojson.Feature) error
+	UpdateMasterMapFeature(string, string, string, *geojson.Feature) error
+	DeleteMasterMapFeature(string, string, string) error
+	GetMasterMapVersions(string) ([]types.MasterMapVersion, error)
+	ActivateMasterMapVersion(string, string) error
+	RollbackMasterMap(string) (string, error)
+	DeleteMasterMapVersion(string, string) error
+	ValidateMasterMap(string, string) (*types.MasterMapValidation, error)
+	CountMasterMapFeatures(string, string, string) (int, error)
+	CreateJob(*types.Job) error
+	UpdateJob(*types.Job) error
+	UpdateJobFile(*types.Job, int) error
+	RestartJob(*types.Job) error
+	GetJob(string) (*types.Job, error)
+	GetJobs() ([]types.Job, error)
+}
@@ -1,69 +1,372 @@
-package types
-
-import (
-	"mime/multipart"
-	"regexp"
-)
-
-type RasterKeySettings struct {
-	Type    string
-	NumChar int
-	Regex   *regexp.Regexp
-}
-type RasterFeatureSettings struct {
-	XPosition string
-	YPosition string
-	Margin    float64
-}
-type GeoreferenceSettings struct {
-	MasterMap             string
-	AttrKey               string
-	RasterKeySettings     *RasterKeySettings
-	TargetDir             string
-	SeparateDirAttrs      []string
-	RasterFeatureSettings *RasterFeatureSettings
-}
-type GeoreferenceRequest struct {
-	Raster   []*multipart.FileHeader
-	Settings *GeoreferenceSettings
-}
-
-type MasterMap struct {
-	Name      string `json:"name"`
-	Dimension int    `json:"dimension"`
-	Srid      int    `json:"srid"`
-	Category  string `json:"type"`
-}
-type MasterMapAttr struct {
-	Name     string `json:"name"`
-	Category string `json:"type"`
-}
-type Dimension struct {
-	Length, Width float64
-}
-type Diagonal struct {
-	TopLeft, TopRight, BottomLeft, BottomRight Coord
-}
-type Margin struct {
-	MarginX, MarginY float64
-}
-type Coord []float64
-
-type FeaturePoints struct {
-	Points []Coord `json:"points"`
-}
-
-type Extent struct {
-	MinX float64 `json:"minX"`
-	MinY float64 `json:"minY"`
-	MaxX float64 `json:"maxX"`
-	MaxY float64 `json:"maxY"`
-}
-
-type WorldFileParameter struct {
-	A, D, B, E, C, F float64
-}
-type Result struct {
-	Id    string
-	Error error
-}
+package types
+
+import (
+	"regexp"
+	"time"
+)
+
+type RasterKeySettings struct {
+	Type    string
+	NumChar int
+	Regex   *regexp.Regexp
+}
+
+// Position of the feature inside the map frame, along the side where the feature
+// does not fill the frame.
+const (
+	PositionLeft   = "left"
+	PositionCenter = "center"
+	PositionRight  = "right"
+	PositionTop    = "top"
+	PositionMiddle = "middle"
+	PositionBottom = "bottom"
+)
+
+// RasterFeatureSettings describes where the feature sits in the map frame. Margins
+// are fractions of the feature size, Margin is the total of two opposite sides.
+type RasterFeatureSettings struct {
+	XPosition    string
+	YPosition    string
+	Margin       float64
+	MarginLeft   float64
+	MarginRight  float64
+	MarginTop    float64
+	MarginBottom float64
+}
+type GeoreferenceSettings struct {
+	MasterMap             string
+	AttrKey               string
+	RasterKeySettings     *RasterKeySettings
+	TargetDir             string
+	SeparateDirAttrs      []string
+	RasterFeatureSettings *RasterFeatureSettings
+	QualityThreshold      float64
+	// TargetSrid is the SRID the world files are written in, 0 keeps the master map SRID
+	TargetSrid int
+	// Srid and SpatialReference (WKT) describe the CRS of the world files
+	Srid             int
+	SpatialReference string
+	// sidecars written next to each world file
+	WritePrj    bool
+	WriteAuxXml bool
+	// AllowAmbiguousKey georeferences a key matching several features with the
+	// extent of all of them, otherwise the raster fails with ErrAmbiguousKey
+	AllowAmbiguousKey bool
+	// DryRun computes the results without writing anything under TargetDir
+	DryRun bool
+	// DebugOverlay writes a PNG of the detected frame and the matched polygon
+	DebugOverlay bool
+	// Refine matches the printed polygon outline after the bounding box fit
+	Refine bool
+	// Rectify warps a raster whose frame is not a parallelogram with a homography,
+	// the world file is written for the rectified copy
+	Rectify bool
+	// OutputFormat is one of OutputWorldFile, OutputGeoTiff or OutputBoth
+	OutputFormat string
+	// NorthUp resamples the GeoTIFF so that it has no rotation terms
+	NorthUp bool
+}
+
+// Output formats of a georeferenced raster, the raster copy with its world file, a
+// GeoTIFF or both.
+const (
+	OutputWorldFile = "worldfile"
+	OutputGeoTiff   = "geotiff"
+	OutputBoth      = "both"
+)
+
+type RasterFile struct {
+	Filename string
+	Path     string
+}
+type GeoreferenceRequest struct {
+	Raster   []RasterFile
+	Settings *GeoreferenceSettings
+}
+
+// MasterMapImportSettings describes how an uploaded layer is stored as master map.
+type MasterMapImportSettings struct {
+	Name    string
+	AttrKey string
+	// Preview only infers the schema, nothing is stored
+	Preview bool
+	// Srid of the features, from the srid field or the uploaded file
+	Srid int
+	// Version names the upload, it is required when the master map exists
+	Version string
+	// Activate makes a new version of an existing master map the active one
+	Activate bool
+	// Validate checks the AttrKey values and the geometries once the features
+	// are copied, with Preview the import is rolled back after the check
+	Validate bool
+	// OnProgress is told the current import stage and the features done so far
+	OnProgress func(stage string, features int)
+}
+
+// FeatureQuery selects master map features, the zero value selects all of them.
+type FeatureQuery struct {
+	// Filters are attribute = value conditions, compared as text
+	Filters map[string]string
+	BBox    *Extent
+	// BBoxSrid is the CRS of BBox, 0 is the master map CRS
+	BBoxSrid int
+	// Srid of the returned geometries, 0 keeps the master map CRS
+	Srid   int
+	Limit  int
+	Offset int
+}
+
+// stages of a master map import
+const (
+	ImportUpload = "upload"
+	ImportScan   = "scan"
+	ImportCopy   = "copy"
+	ImportIndex  = "index"
+	ImportDone   = "done"
+	ImportFailed = "failed"
+)
+
+// ImportProgress is the state of a running or finished master map import.
+type ImportProgress struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	Filename  string    `json:"filename"`
+	Stage     string    `json:"stage"`
+	Bytes     int64     `json:"bytes"`
+	Features  int       `json:"features"`
+	Err       string    `json:"error"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// MasterMapColumn is one column inferred from the layer properties.
+type MasterMapColumn struct {
+	Name     string `json:"name"`
+	Property string `json:"property"`
+	Category string `json:"type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// MasterMapSchema is the inferred table of an uploaded layer.
+type MasterMapSchema struct {
+	Name         string            `json:"name"`
+	Features     int               `json:"features"`
+	GeometryType string            `json:"geometry_type"`
+	Srid         int               `json:"srid"`
+	Columns      []MasterMapColumn `json:"columns"`
+	Committed    bool              `json:"committed"`
+	// Version and Active are set when the import is stored
+	Version    string               `json:"version,omitempty"`
+	Active     bool                 `json:"active"`
+	Validation *MasterMapValidation `json:"validation,omitempty"`
+}
+
+// FeatureIssue points to a feature found by a master map validation.
+type FeatureIssue struct {
+	Gid    int    `json:"gid"`
+	Key    string `json:"key"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ValidationIssues counts the features with one kind of problem, Features holds
+// the first of them.
+type ValidationIssues struct {
+	Count    int            `json:"count"`
+	Features []FeatureIssue `json:"features"`
+}
+
+type DuplicateKey struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+	Gids  []int  `json:"gids"`
+}
+
+// MasterMapValidation reports the features of a master map that georeference
+// cannot match unambiguously by the key attribute.
+type MasterMapValidation struct {
+	MasterMap string `json:"master_map"`
+	Key       string `json:"key"`
+	Features  int    `json:"features"`
+	// Valid is false when keys are duplicated or null, or geometries are invalid or empty
+	Valid bool `json:"valid"`
+	// DuplicateCount is the number of duplicated keys, DuplicateKeys holds the first of them
+	DuplicateCount    int              `json:"duplicate_count"`
+	DuplicateKeys     []DuplicateKey   `json:"duplicate_keys"`
+	NullKeys          ValidationIssues `json:"null_keys"`
+	InvalidGeometries ValidationIssues `json:"invalid_geometries"`
+	EmptyGeometries   ValidationIssues `json:"empty_geometries"`
+	// MultipartFeatures are only a warning, their extent covers every part
+	MultipartFeatures ValidationIssues `json:"multipart_features"`
+}
+type MasterMap struct {
+	Name      string `json:"name"`
+	Dimension int    `json:"dimension"`
+	Srid      int    `json:"srid"`
+	Category  string `json:"type"`
+	// Version is the active version, empty for master maps imported before versioning
+	Version string `json:"version"`
+}
+
+// MasterMapVersion is one import of a master map. The active version is the
+// table named after the master map, the others are kept as {name}__{version}.
+type MasterMapVersion struct {
+	MasterMap   string     `json:"master_map"`
+	Version     string     `json:"version"`
+	Table       string     `json:"table"`
+	Active      bool       `json:"active"`
+	Features    int        `json:"features"`
+	Srid        int        `json:"srid"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatedAt *time.Time `json:"activated_at"`
+}
+type MasterMapAttr struct {
+	Name     string `json:"name"`
+	Category string `json:"type"`
+}
+type Dimension struct {
+	Length, Width float64
+}
+type Diagonal struct {
+	TopLeft, TopRight, BottomLeft, BottomRight Coord
+}
+type Margin struct {
+	MarginX, MarginY float64
+}
+type Coord []float64
+
+type FeaturePoints struct {
+	Points []Coord `json:"points"`
+}
+
+type Extent struct {
+	MinX float64 `json:"minX"`
+	MinY float64 `json:"minY"`
+	MaxX float64 `json:"maxX"`
+	MaxY float64 `json:"maxY"`
+}
+
+type WorldFileParameter struct {
+	A float64 `json:"a"`
+	D float64 `json:"d"`
+	B float64 `json:"b"`
+	E float64 `json:"e"`
+	C float64 `json:"c"`
+	F float64 `json:"f"`
+}
+
+type CornerResidual struct {
+	Pixel    Coord   `json:"pixel"`
+	Target   Coord   `json:"target"`
+	DX       float64 `json:"dx"`
+	DY       float64 `json:"dy"`
+	Distance float64 `json:"distance"`
+}
+
+// GeoreferenceFit is the least squares affine fit of the frame corners, residuals
+// are in map units.
+type GeoreferenceFit struct {
+	Parameter WorldFileParameter `json:"parameter"`
+	Residuals []CornerResidual   `json:"residuals"`
+	RMSE      float64            `json:"rmse"`
+}
+
+// Refinement reports the polygon outline matching that refines the bounding box
+// fit. Distances are the RMS distance in raster pixels between the polygon outline
+// and the nearest ink, capped so unrelated ink does not dominate.
+type Refinement struct {
+	InitialDistance float64 `json:"initial_distance"`
+	RefinedDistance float64 `json:"refined_distance"`
+	// Improvement is the share of the initial distance removed by the refinement
+	Improvement float64 `json:"improvement"`
+	// ShiftX and ShiftY move the feature center, in map units
+	ShiftX   float64 `json:"shift_x"`
+	ShiftY   float64 `json:"shift_y"`
+	Scale    float64 `json:"scale"`
+	Rotation float64 `json:"rotation"`
+	// Applied tells whether Parameter replaced the bounding box solution
+	Applied   bool               `json:"applied"`
+	Parameter WorldFileParameter `json:"parameter"`
+	// Error is why the refinement failed, the bounding box solution is kept then
+	Error string `json:"error,omitempty"`
+}
+
+// Quality scores a georeference between 0 (wrong) and 1 (good). Every check is
+// scored on its own, Reasons explains the checks that scored low.
+type Quality struct {
+	Score       float64  `json:"score"`
+	AspectRatio float64  `json:"aspect_ratio"`
+	AreaShare   float64  `json:"area_share"`
+	Residual    float64  `json:"residual"`
+	Reasons     []string `json:"reasons"`
+}
+
+// Error codes of a georeference result, one for every step of the worker.
+const (
+	ErrUnsupportedRaster = "UNSUPPORTED_RASTER"
+	ErrOpenRaster        = "OPEN_RASTER"
+	ErrImageDimension    = "IMAGE_DIMENSION"
+	ErrRasterKey         = "RASTER_KEY"
+	ErrAttributesValue   = "ATTRIBUTES_VALUE"
+	ErrTargetDir         = "TARGET_DIR"
+	ErrSaveRaster        = "SAVE_RASTER"
+	ErrExtent            = "EXTENT"
+	ErrFeatureDetection  = "FEATURE_DETECTION"
+	ErrGeoreference      = "GEOREFERENCE"
+	ErrWorldFile         = "WORLD_FILE"
+	ErrSidecar           = "SIDECAR"
+	ErrDebugOverlay      = "DEBUG_OVERLAY"
+	ErrRectify           = "RECTIFY"
+	ErrGeoTiff           = "GEOTIFF"
+	ErrKeyNotFound       = "KEY_NOT_FOUND"
+	ErrAmbiguousKey      = "AMBIGUOUS_KEY"
+)
+
+type Result struct {
+	Filename         string              `json:"filename"`
+	Status           string              `json:"status"`
+	RasterKey        string              `json:"raster_key"`
+	Feature          string              `json:"feature"`
+	MasterMapVersion string              `json:"master_map_version"`
+	Extent           *Extent             `json:"extent"`
+	RasterPath       string              `json:"raster_path"`
+	OriginalPath     string              `json:"original_path"`
+	WorldFilePath    string              `json:"world_file_path"`
+	PrjPath          string              `json:"prj_path"`
+	AuxXmlPath       string              `json:"aux_xml_path"`
+	OverlayPath      string              `json:"overlay_path"`
+	GeoTiffPath      string              `json:"geotiff_path"`
+	Srid             int                 `json:"srid"`
+	Parameter        *WorldFileParameter `json:"parameter"`
+	Residuals        []CornerResidual    `json:"residuals"`
+	RMSE             float64             `json:"rmse"`
+	Quality          *Quality            `json:"quality"`
+	Refinement       *Refinement         `json:"refinement,omitempty"`
+	Perspective      float64             `json:"perspective"`
+	Rectified        bool                `json:"rectified"`
+	NeedsReview      bool                `json:"needs_review"`
+	ErrorCode        string              `json:"error_code"`
+	ErrorMessage     string              `json:"error"`
+	Error            error               `json:"-"`
+}
+
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+type Job struct {
+	Id        string            `json:"id"`
+	Status    string            `json:"status"`
+	DirPath   string            `json:"dir_path"`
+	Total     int               `json:"total"`
+	Success   int               `json:"success"`
+	Fail      int               `json:"fail"`
+	Err       string            `json:"error"`
+	Files     []Result          `json:"files,omitempty"`
+	Reports   []string          `json:"reports"`
+	Params    map[string]string `json:"-"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
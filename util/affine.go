@@ -0,0 +1,237 @@
+package util
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/nahrx/geomatis-api/types"
+)
+
+// CalculateGeoreferenceParameters solves the six world file parameters by least
+// squares from the four detected frame corners to the margin adjusted extent corners.
+// The similarity solution is used to decide which frame corner belongs to which
+// extent corner, so rotated rasters keep working.
+func CalculateGeoreferenceParameters(img types.Dimension, rasterPoints []types.Coord, extent types.Extent, feature *types.RasterFeatureSettings) (*types.GeoreferenceFit, error) {
+	diagonal, err := FindDiagonalPoints(rasterPoints)
+	if err != nil {
+		return nil, err
+	}
+	margin := (feature.MarginLeft + feature.MarginRight + feature.MarginTop + feature.MarginBottom) / 2
+	initial := similarityParameters(img, rasterPoints, extent, margin)
+
+	frame, err := frameExtent(*initial, diagonal, extent, feature)
+	if err != nil {
+		return nil, err
+	}
+	pixels := []types.Coord{diagonal.TopLeft, diagonal.TopRight, diagonal.BottomRight, diagonal.BottomLeft}
+	corners := []types.Coord{{frame.MinX, frame.MaxY}, {frame.MaxX, frame.MaxY}, {frame.MaxX, frame.MinY}, {frame.MinX, frame.MinY}}
+
+	shift, best := 0, math.Inf(1)
+	for k := 0; k < 4; k++ {
+		var sum float64
+		for i, p := range pixels {
+			x, y := ApplyWorldFileParameter(*initial, p)
+			c := corners[(i+k)%4]
+			sum += (x-c[0])*(x-c[0]) + (y-c[1])*(y-c[1])
+		}
+		if sum < best {
+			shift, best = k, sum
+		}
+	}
+	targets := make([]types.Coord, 4)
+	for i := range pixels {
+		targets[i] = corners[(i+shift)%4]
+	}
+
+	p, err := fitAffine(pixels, targets)
+	if err != nil {
+		return nil, err
+	}
+	return evaluateFit(*p, pixels, targets), nil
+}
+
+// RefitParameter evaluates another world file, such as a refinement, against the
+// corners of a fit and returns it with its own residuals.
+func RefitParameter(fit *types.GeoreferenceFit, p types.WorldFileParameter) *types.GeoreferenceFit {
+	pixels := make([]types.Coord, len(fit.Residuals))
+	targets := make([]types.Coord, len(fit.Residuals))
+	for i, r := range fit.Residuals {
+		pixels[i], targets[i] = r.Pixel, r.Target
+	}
+	return evaluateFit(p, pixels, targets)
+}
+
+// evaluateFit computes the residuals of p from the pixels to their targets.
+func evaluateFit(p types.WorldFileParameter, pixels, targets []types.Coord) *types.GeoreferenceFit {
+	fit := types.GeoreferenceFit{Parameter: p}
+	var sumSquare float64
+	for i, pixel := range pixels {
+		x, y := ApplyWorldFileParameter(p, pixel)
+		r := types.CornerResidual{
+			Pixel:  pixel,
+			Target: targets[i],
+			DX:     x - targets[i][0],
+			DY:     y - targets[i][1],
+		}
+		r.Distance = math.Hypot(r.DX, r.DY)
+		sumSquare += r.Distance * r.Distance
+		fit.Residuals = append(fit.Residuals, r)
+	}
+	if len(pixels) > 0 {
+		fit.RMSE = math.Sqrt(sumSquare / float64(len(pixels)))
+	}
+	return &fit
+}
+
+// ApplyWorldFileParameter maps a pixel to map coordinates.
+func ApplyWorldFileParameter(p types.WorldFileParameter, pixel types.Coord) (float64, float64) {
+	return p.A*pixel[0] + p.B*pixel[1] + p.C, p.D*pixel[0] + p.E*pixel[1] + p.F
+}
+
+func distanceCoord(a, b types.Coord) float64 {
+	return math.Hypot(a[0]-b[0], a[1]-b[1])
+}
+
+// frameExtent returns the map extent covered by the detected frame. The frame minus
+// its side margins holds the feature extent, fitted on its longest side and anchored
+// on the other side by the feature x / y position. The margins are on the sides of
+// the frame as it lies in the raster, initial tells which map side each one faces.
+func frameExtent(initial types.WorldFileParameter, d types.Diagonal, extent types.Extent, feature *types.RasterFeatureSettings) (types.Extent, error) {
+	frameWidth := (distanceCoord(d.TopLeft, d.TopRight) + distanceCoord(d.BottomLeft, d.BottomRight)) / 2
+	frameHeight := (distanceCoord(d.TopLeft, d.BottomLeft) + distanceCoord(d.TopRight, d.BottomRight)) / 2
+	// raster x axis runs along map y when the raster is rotated a quarter turn
+	pixelX, pixelY := frameWidth, frameHeight
+	if quarterTurn(initial) {
+		pixelX, pixelY = frameHeight, frameWidth
+	}
+	west, east, north, south := mapMargins(initial, feature)
+	if pixelX == 0 || pixelY == 0 {
+		return types.Extent{}, fmt.Errorf("Detected frame has no area")
+	}
+	featureX := pixelX / (1 + west + east)
+	featureY := pixelY / (1 + north + south)
+	deltaX := extent.MaxX - extent.MinX
+	deltaY := extent.MaxY - extent.MinY
+	scale := math.Max(deltaX/featureX, deltaY/featureY)
+	if scale == 0 || math.IsNaN(scale) || math.IsInf(scale, 0) {
+		return types.Extent{}, fmt.Errorf("Feature extent has no area")
+	}
+
+	// unused room of the feature box, on the side the extent does not fill
+	slackX := featureX*scale - deltaX
+	slackY := featureY*scale - deltaY
+	featureMinX := extent.MinX - slackX/2
+	switch feature.XPosition {
+	case types.PositionLeft:
+		featureMinX = extent.MinX
+	case types.PositionRight:
+		featureMinX = extent.MinX - slackX
+	}
+	featureMaxY := extent.MaxY + slackY/2
+	switch feature.YPosition {
+	case types.PositionTop:
+		featureMaxY = extent.MaxY
+	case types.PositionBottom:
+		featureMaxY = extent.MaxY + slackY
+	}
+
+	minX := featureMinX - west*featureX*scale
+	maxY := featureMaxY + north*featureY*scale
+	return types.Extent{
+		MinX: minX,
+		MinY: maxY - pixelY*scale,
+		MaxX: minX + pixelX*scale,
+		MaxY: maxY,
+	}, nil
+}
+
+func quarterTurn(p types.WorldFileParameter) bool {
+	return math.Abs(p.A) < math.Abs(p.D)
+}
+
+// mapMargins returns the margins of the frame sides in the raster by the map side
+// they face under p.
+func mapMargins(p types.WorldFileParameter, feature *types.RasterFeatureSettings) (west, east, north, south float64) {
+	if quarterTurn(p) {
+		// the frame top and bottom face west or east, left and right south or north
+		west, east = feature.MarginTop, feature.MarginBottom
+		if p.B < 0 {
+			west, east = east, west
+		}
+		south, north = feature.MarginLeft, feature.MarginRight
+		if p.D < 0 {
+			south, north = north, south
+		}
+		return
+	}
+	west, east = feature.MarginLeft, feature.MarginRight
+	if p.A < 0 {
+		west, east = east, west
+	}
+	north, south = feature.MarginTop, feature.MarginBottom
+	if p.E > 0 {
+		north, south = south, north
+	}
+	return
+}
+
+// fitAffine solves x' = A x + B y + C and y' = D x + E y + F by least squares.
+func fitAffine(pixels, targets []types.Coord) (*types.WorldFileParameter, error) {
+	var m [3][3]float64
+	var bx, by [3]float64
+	for i, p := range pixels {
+		row := [3]float64{p[0], p[1], 1}
+		for r := 0; r < 3; r++ {
+			for c := 0; c < 3; c++ {
+				m[r][c] += row[r] * row[c]
+			}
+			bx[r] += row[r] * targets[i][0]
+			by[r] += row[r] * targets[i][1]
+		}
+	}
+	x, err := solve3(m, bx)
+	if err != nil {
+		return nil, err
+	}
+	y, err := solve3(m, by)
+	if err != nil {
+		return nil, err
+	}
+	return &types.WorldFileParameter{
+		A: x[0], B: x[1], C: x[2],
+		D: y[0], E: y[1], F: y[2],
+	}, nil
+}
+
+// solve3 solves m v = b with gaussian elimination and partial pivoting.
+func solve3(m [3][3]float64, b [3]float64) ([3]float64, error) {
+	for col := 0; col < 3; col++ {
+		pivot := col
+		for r := col + 1; r < 3; r++ {
+			if math.Abs(m[r][col]) > math.Abs(m[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return [3]float64{}, fmt.Errorf("Frame corners are degenerate, affine parameters can not be solved")
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		b[col], b[pivot] = b[pivot], b[col]
+		for r := col + 1; r < 3; r++ {
+			f := m[r][col] / m[col][col]
+			for c := col; c < 3; c++ {
+				m[r][c] -= f * m[col][c]
+			}
+			b[r] -= f * b[col]
+		}
+	}
+	var v [3]float64
+	for r := 2; r >= 0; r-- {
+		sum := b[r]
+		for c := r + 1; c < 3; c++ {
+			sum -= m[r][c] * v[c]
+		}
+		v[r] = sum / m[r][r]
+	}
+	return v, nil
+}
@@ -0,0 +1,180 @@
+package util
+
+import (
+	"math"
+	"testing"
+
+	"github.com/nahrx/geomatis-api/types"
+)
+
+func TestFitAffine(t *testing.T) {
+	pixels := []types.Coord{{100, 100}, {900, 120}, {880, 700}, {90, 690}}
+	tests := []struct {
+		name string
+		p    types.WorldFileParameter
+	}{
+		{"north up", types.WorldFileParameter{A: 2, E: -2, C: 500000, F: 9000000}},
+		{"rotated", types.WorldFileParameter{A: 0.8, B: 0.6, D: 0.6, E: -0.8, C: 10, F: 20}},
+		{"sheared", types.WorldFileParameter{A: 1.5, B: 0.2, D: -0.1, E: -0.9, C: -3, F: 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets := make([]types.Coord, len(pixels))
+			for i, p := range pixels {
+				x, y := ApplyWorldFileParameter(tt.p, p)
+				targets[i] = types.Coord{x, y}
+			}
+			got, err := fitAffine(pixels, targets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []float64{tt.p.A, tt.p.B, tt.p.C, tt.p.D, tt.p.E, tt.p.F}
+			for i, v := range []float64{got.A, got.B, got.C, got.D, got.E, got.F} {
+				if math.Abs(v-want[i]) > 1e-6 {
+					t.Errorf("parameter %d = %v, want %v", i, v, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFitAffineDegenerate(t *testing.T) {
+	pixels := []types.Coord{{0, 0}, {1, 1}, {2, 2}, {3, 3}}
+	if _, err := fitAffine(pixels, pixels); err == nil {
+		t.Error("collinear corners must not be solved")
+	}
+}
+
+func TestCalculateGeoreferenceParameters(t *testing.T) {
+	img := types.Dimension{Length: 1000, Width: 800}
+	frame := []types.Coord{{100, 100}, {900, 100}, {900, 700}, {100, 700}}
+	tests := []struct {
+		name    string
+		extent  types.Extent
+		feature types.RasterFeatureSettings
+		// map coordinates of the top left and bottom right frame corners
+		topLeft, bottomRight types.Coord
+	}{
+		{
+			name:        "same aspect",
+			extent:      types.Extent{MinX: 0, MinY: 0, MaxX: 800, MaxY: 600},
+			feature:     types.RasterFeatureSettings{XPosition: types.PositionCenter, YPosition: types.PositionMiddle},
+			topLeft:     types.Coord{0, 600},
+			bottomRight: types.Coord{800, 0},
+		},
+		{
+			name:        "margins",
+			extent:      types.Extent{MinX: 0, MinY: 0, MaxX: 800, MaxY: 600},
+			feature:     types.RasterFeatureSettings{XPosition: types.PositionCenter, YPosition: types.PositionMiddle, MarginLeft: 0.1, MarginRight: 0.1, MarginTop: 0.1, MarginBottom: 0.1},
+			topLeft:     types.Coord{-80, 660},
+			bottomRight: types.Coord{880, -60},
+		},
+		{
+			name:        "narrow feature centered",
+			extent:      types.Extent{MinX: 0, MinY: 0, MaxX: 600, MaxY: 600},
+			feature:     types.RasterFeatureSettings{XPosition: types.PositionCenter, YPosition: types.PositionMiddle},
+			topLeft:     types.Coord{-100, 600},
+			bottomRight: types.Coord{700, 0},
+		},
+		{
+			name:        "narrow feature on the right",
+			extent:      types.Extent{MinX: 0, MinY: 0, MaxX: 600, MaxY: 600},
+			feature:     types.RasterFeatureSettings{XPosition: types.PositionRight, YPosition: types.PositionMiddle},
+			topLeft:     types.Coord{-200, 600},
+			bottomRight: types.Coord{600, 0},
+		},
+		{
+			name:        "narrow feature on the left",
+			extent:      types.Extent{MinX: 0, MinY: 0, MaxX: 600, MaxY: 600},
+			feature:     types.RasterFeatureSettings{XPosition: types.PositionLeft, YPosition: types.PositionMiddle},
+			topLeft:     types.Coord{0, 600},
+			bottomRight: types.Coord{800, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fit, err := CalculateGeoreferenceParameters(img, frame, tt.extent, &tt.feature)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fit.RMSE > 1e-6 {
+				t.Errorf("RMSE = %v, want 0", fit.RMSE)
+			}
+			for _, c := range []struct{ pixel, want types.Coord }{{frame[0], tt.topLeft}, {frame[2], tt.bottomRight}} {
+				x, y := ApplyWorldFileParameter(fit.Parameter, c.pixel)
+				if math.Hypot(x-c.want[0], y-c.want[1]) > 1e-6 {
+					t.Errorf("pixel %v maps to (%v, %v), want %v", c.pixel, x, y, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCalculateGeoreferenceParametersRotated(t *testing.T) {
+	// a portrait sheet scanned a quarter turn counterclockwise, north is on the
+	// left of the raster and east on the top
+	img := types.Dimension{Length: 1600, Width: 800}
+	frame := []types.Coord{{100, 100}, {1500, 100}, {1500, 700}, {100, 700}}
+	extent := types.Extent{MinX: 0, MinY: 0, MaxX: 500, MaxY: 1000}
+	// the margins are on the sides of the frame in the raster, left faces north,
+	// right south, top east and bottom west
+	feature := types.RasterFeatureSettings{
+		XPosition:    types.PositionCenter,
+		YPosition:    types.PositionMiddle,
+		MarginLeft:   0.1,
+		MarginRight:  0.3,
+		MarginTop:    0.2,
+		MarginBottom: 0,
+	}
+	fit, err := CalculateGeoreferenceParameters(img, frame, extent, &feature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fit.RMSE > 1e-6 {
+		t.Errorf("RMSE = %v, want 0", fit.RMSE)
+	}
+	want := []types.Coord{{600, 1100}, {600, -300}, {0, -300}, {0, 1100}}
+	for i, pixel := range frame {
+		x, y := ApplyWorldFileParameter(fit.Parameter, pixel)
+		if math.Hypot(x-want[i][0], y-want[i][1]) > 1e-6 {
+			t.Errorf("pixel %v maps to (%v, %v), want %v", pixel, x, y, want[i])
+		}
+	}
+	// the feature box is the extent again
+	box := FeatureBox(fit, &feature)
+	wantBox := []types.Coord{{0, 1000}, {500, 1000}, {500, 0}, {0, 0}}
+	for i, c := range box {
+		if distanceCoord(c, wantBox[i]) > 1e-6 {
+			t.Errorf("feature box corner %d = %v, want %v", i, c, wantBox[i])
+		}
+	}
+}
+
+func TestRefitParameter(t *testing.T) {
+	img := types.Dimension{Length: 1000, Width: 800}
+	frame := []types.Coord{{100, 100}, {900, 100}, {900, 700}, {100, 700}}
+	feature := types.RasterFeatureSettings{XPosition: types.PositionCenter, YPosition: types.PositionMiddle}
+	fit, err := CalculateGeoreferenceParameters(img, frame, types.Extent{MinX: 0, MinY: 0, MaxX: 800, MaxY: 600}, &feature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	same := RefitParameter(fit, fit.Parameter)
+	if same.RMSE > 1e-9 || len(same.Residuals) != len(fit.Residuals) {
+		t.Errorf("refit of the same parameter : RMSE %v, %d residuals", same.RMSE, len(same.Residuals))
+	}
+
+	// a shift of (3, 4) map units moves every corner by 5
+	shifted := fit.Parameter
+	shifted.C += 3
+	shifted.F += 4
+	refit := RefitParameter(fit, shifted)
+	if refit.Parameter != shifted || math.Abs(refit.RMSE-5) > 1e-9 {
+		t.Errorf("refit of a shifted parameter : RMSE %v, want 5", refit.RMSE)
+	}
+	for i, r := range refit.Residuals {
+		if distanceCoord(r.Pixel, fit.Residuals[i].Pixel) != 0 || distanceCoord(r.Target, fit.Residuals[i].Target) != 0 || math.Abs(r.Distance-5) > 1e-9 {
+			t.Errorf("residual %d = %+v", i, r)
+		}
+	}
+}
@@ -0,0 +1,482 @@
+package util
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/nahrx/geomatis-api/types"
+)
+
+// FeatureDetector finds the four corner points of the map frame in a raster file.
+// Points are returned in pixel coordinates of the (EXIF oriented) raster.
+type FeatureDetector interface {
+	GetRasterFeaturePoints(filePath string) ([]types.Coord, error)
+}
+
+// NewFeatureDetector returns the detector backend by name. "native" (default) runs
+// the pure Go pipeline, "python" calls rasterFeaturePoints in pypy.py.
+func NewFeatureDetector(name string) (FeatureDetector, error) {
+	switch strings.ToLower(name) {
+	case "", "native", "go":
+		return NewNativeFeatureDetector(), nil
+	case "python":
+		return &PythonFeatureDetector{}, nil
+	}
+	return nil, fmt.Errorf("Feature detector %s is not valid. Only native or python allowed.", name)
+}
+
+// PythonFeatureDetector runs pypy.py (python + opencv) in a subprocess.
+type PythonFeatureDetector struct{}
+
+func (d *PythonFeatureDetector) GetRasterFeaturePoints(filePath string) ([]types.Coord, error) {
+	return GetRasterFeaturePoints(filePath)
+}
+
+// NativeFeatureDetector is the Go port of pypy.py : grayscale, otsu threshold,
+// external contours, polygon approximation and the largest 4 vertex contour.
+type NativeFeatureDetector struct {
+	ScalePercent  int     // raster is resized before detection, same as pypy.py scale_percent
+	EpsilonFactor float64 // approxPolyDP epsilon relative to the contour perimeter
+}
+
+func NewNativeFeatureDetector() *NativeFeatureDetector {
+	return &NativeFeatureDetector{
+		ScalePercent:  20,
+		EpsilonFactor: 0.015,
+	}
+}
+
+type point struct {
+	X, Y int
+}
+
+func (d *NativeFeatureDetector) GetRasterFeaturePoints(filePath string) ([]types.Coord, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open raster. error : %s.", err.Error())
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode raster. error : %s.", err.Error())
+	}
+	if d.ScalePercent <= 0 || d.ScalePercent > 100 {
+		return nil, fmt.Errorf("Scale percent must be between 1 and 100")
+	}
+
+	bounds := img.Bounds()
+	width := bounds.Dx() * d.ScalePercent / 100
+	height := bounds.Dy() * d.ScalePercent / 100
+	if width < 3 || height < 3 {
+		return nil, fmt.Errorf("Raster is too small for feature detection")
+	}
+	gray := resizeGray(img, width, height)
+	binary := thresholdOtsuInv(gray)
+
+	var containerPeri float64
+	var containerApprox []point
+	for _, contour := range findExternalContours(binary, width, height) {
+		peri := arcLength(contour)
+		approx := approxPolyDP(contour, d.EpsilonFactor*peri)
+		if len(approx) == 4 && containerPeri < peri {
+			containerPeri = peri
+			containerApprox = approx
+		}
+	}
+	if containerApprox == nil {
+		return nil, fmt.Errorf("No rectangular feature found in raster")
+	}
+
+	orientation := rasterOrientation(filePath)
+	scale := 100 / float64(d.ScalePercent)
+	points := make([]types.Coord, len(containerApprox))
+	for i, p := range containerApprox {
+		points[i] = orientPoint(float64(p.X)*scale, float64(p.Y)*scale, float64(bounds.Dx()), float64(bounds.Dy()), orientation)
+	}
+	return points, nil
+}
+
+// rasterOrientation reads the EXIF orientation tag, rasters without EXIF are upright.
+func rasterOrientation(filePath string) int {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	orientation, err := GetOrientationTag(file)
+	if err != nil {
+		return 0
+	}
+	return orientation
+}
+
+// orientPoint maps a point of the stored raster into the displayed raster, the same
+// way opencv imread applies the EXIF orientation.
+func orientPoint(x, y, w, h float64, orientation int) types.Coord {
+	switch orientation {
+	case 2:
+		return types.Coord{w - 1 - x, y}
+	case 3:
+		return types.Coord{w - 1 - x, h - 1 - y}
+	case 4:
+		return types.Coord{x, h - 1 - y}
+	case 5:
+		return types.Coord{y, x}
+	case 6:
+		return types.Coord{h - 1 - y, x}
+	case 7:
+		return types.Coord{h - 1 - y, w - 1 - x}
+	case 8:
+		return types.Coord{y, w - 1 - x}
+	}
+	return types.Coord{x, y}
+}
+
+// areaWeights lists, for every source index, the destination cells it covers and the
+// covered fraction. Used for area (box) downscaling like opencv INTER_AREA.
+func areaWeights(src, dst int) [][2]float64 {
+	weights := make([][2]float64, 0, src*2)
+	ratio := float64(dst) / float64(src)
+	for i := 0; i < src; i++ {
+		start := float64(i) * ratio
+		end := float64(i+1) * ratio
+		cell := math.Floor(start)
+		if end > cell+1 && int(cell)+1 < dst {
+			weights = append(weights, [2]float64{cell, cell + 1 - start}, [2]float64{cell + 1, end - cell - 1})
+			continue
+		}
+		weights = append(weights, [2]float64{cell, end - start}, [2]float64{-1, 0})
+	}
+	return weights
+}
+
+// resizeGray converts img to grayscale and downscales it to width x height.
+func resizeGray(img image.Image, width, height int) []uint8 {
+	bounds := img.Bounds()
+	xw := areaWeights(bounds.Dx(), width)
+	yw := areaWeights(bounds.Dy(), height)
+	sum := make([]float64, width*height)
+	row := make([]float64, width)
+
+	luma := func(x, y int) float64 {
+		r, g, b, _ := img.At(x, y).RGBA()
+		return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+	}
+	if ycbcr, ok := img.(*image.YCbCr); ok {
+		luma = func(x, y int) float64 {
+			return float64(ycbcr.Y[ycbcr.YOffset(x, y)])
+		}
+	} else if g, ok := img.(*image.Gray); ok {
+		luma = func(x, y int) float64 {
+			return float64(g.Pix[g.PixOffset(x, y)])
+		}
+	}
+
+	for sy := 0; sy < bounds.Dy(); sy++ {
+		for i := range row {
+			row[i] = 0
+		}
+		for sx := 0; sx < bounds.Dx(); sx++ {
+			v := luma(bounds.Min.X+sx, bounds.Min.Y+sy)
+			for _, w := range xw[sx*2 : sx*2+2] {
+				if w[0] >= 0 && w[1] > 0 {
+					row[int(w[0])] += v * w[1]
+				}
+			}
+		}
+		for _, w := range yw[sy*2 : sy*2+2] {
+			if w[0] < 0 || w[1] <= 0 {
+				continue
+			}
+			offset := int(w[0]) * width
+			for dx, v := range row {
+				sum[offset+dx] += v * w[1]
+			}
+		}
+	}
+	gray := make([]uint8, width*height)
+	for i, v := range sum {
+		gray[i] = uint8(math.Min(255, math.Round(v)))
+	}
+	return gray
+}
+
+// thresholdOtsuInv returns the foreground mask of pixels at or below the otsu
+// threshold (dark ink on light paper), like THRESH_BINARY_INV + THRESH_OTSU.
+func thresholdOtsuInv(gray []uint8) []bool {
+	var hist [256]float64
+	for _, v := range gray {
+		hist[v]++
+	}
+	total := float64(len(gray))
+	var sumAll float64
+	for i, h := range hist {
+		sumAll += float64(i) * h
+	}
+
+	var threshold int
+	var sumB, weightB, maxVariance float64
+	for t := 0; t < 256; t++ {
+		weightB += hist[t]
+		if weightB == 0 {
+			continue
+		}
+		weightF := total - weightB
+		if weightF == 0 {
+			break
+		}
+		sumB += float64(t) * hist[t]
+		meanB := sumB / weightB
+		meanF := (sumAll - sumB) / weightF
+		variance := weightB * weightF * (meanB - meanF) * (meanB - meanF)
+		if variance > maxVariance {
+			maxVariance = variance
+			threshold = t
+		}
+	}
+
+	binary := make([]bool, len(gray))
+	for i, v := range gray {
+		binary[i] = int(v) <= threshold
+	}
+	return binary
+}
+
+// 8-neighbourhood in clockwise order (image y axis points down), starting east.
+var neighbours = [8]point{{1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}, {0, -1}, {1, -1}}
+
+func neighbourIndex(from, to point) int {
+	for i, n := range neighbours {
+		if from.X+n.X == to.X && from.Y+n.Y == to.Y {
+			return i
+		}
+	}
+	return -1
+}
+
+// findExternalContours returns the outer border of every foreground component that is
+// not enclosed by another component (RETR_EXTERNAL). Foreground is 8-connected,
+// background 4-connected and everything outside the image counts as background.
+func findExternalContours(binary []bool, width, height int) [][]point {
+	fg := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < width && y < height && binary[y*width+x]
+	}
+
+	// flood fill the background reachable from outside the image
+	outside := make([]bool, len(binary))
+	var stack []point
+	push := func(x, y int) {
+		if x < 0 || y < 0 || x >= width || y >= height {
+			return
+		}
+		i := y*width + x
+		if binary[i] || outside[i] {
+			return
+		}
+		outside[i] = true
+		stack = append(stack, point{x, y})
+	}
+	for x := 0; x < width; x++ {
+		push(x, 0)
+		push(x, height-1)
+	}
+	for y := 0; y < height; y++ {
+		push(0, y)
+		push(width-1, y)
+	}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		push(p.X+1, p.Y)
+		push(p.X-1, p.Y)
+		push(p.X, p.Y+1)
+		push(p.X, p.Y-1)
+	}
+	touchesOutside := func(x, y int) bool {
+		for _, n := range [4]point{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+			nx, ny := x+n.X, y+n.Y
+			if nx < 0 || ny < 0 || nx >= width || ny >= height || outside[ny*width+nx] {
+				return true
+			}
+		}
+		return false
+	}
+
+	// label components, the first pixel in raster order starts the border following
+	visited := make([]bool, len(binary))
+	var contours [][]point
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if !binary[y*width+x] || visited[y*width+x] {
+				continue
+			}
+			external := false
+			visited[y*width+x] = true
+			stack = append(stack[:0], point{x, y})
+			for len(stack) > 0 {
+				p := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if !external && touchesOutside(p.X, p.Y) {
+					external = true
+				}
+				for _, n := range neighbours {
+					nx, ny := p.X+n.X, p.Y+n.Y
+					if fg(nx, ny) && !visited[ny*width+nx] {
+						visited[ny*width+nx] = true
+						stack = append(stack, point{nx, ny})
+					}
+				}
+			}
+			if external {
+				contours = append(contours, followBorder(fg, point{x, y}))
+			}
+		}
+	}
+	return contours
+}
+
+// followBorder traces the outer border starting at start, whose west neighbour is
+// background (Suzuki & Abe border following), and keeps only the corner points like
+// CHAIN_APPROX_SIMPLE.
+func followBorder(fg func(x, y int) bool, start point) []point {
+	// search clockwise around start, beginning at the west neighbour
+	first := -1
+	for k := 0; k < 8; k++ {
+		n := neighbours[(4+k)%8]
+		if fg(start.X+n.X, start.Y+n.Y) {
+			first = (4 + k) % 8
+			break
+		}
+	}
+	if first < 0 {
+		return []point{start}
+	}
+	p1 := point{start.X + neighbours[first].X, start.Y + neighbours[first].Y}
+
+	var chain []point
+	prev, current := p1, start
+	for {
+		chain = append(chain, current)
+		// search counterclockwise around current, beginning after prev
+		from := neighbourIndex(current, prev)
+		var next point
+		for k := 1; k <= 8; k++ {
+			n := neighbours[(from-k+16)%8]
+			if fg(current.X+n.X, current.Y+n.Y) {
+				next = point{current.X + n.X, current.Y + n.Y}
+				break
+			}
+		}
+		if next == start && current == p1 {
+			break
+		}
+		prev, current = current, next
+	}
+
+	// drop points in the middle of horizontal, vertical or diagonal runs
+	n := len(chain)
+	if n < 3 {
+		return chain
+	}
+	var simple []point
+	for i, p := range chain {
+		a, b := chain[(i-1+n)%n], chain[(i+1)%n]
+		if p.X-a.X != b.X-p.X || p.Y-a.Y != b.Y-p.Y {
+			simple = append(simple, p)
+		}
+	}
+	if len(simple) == 0 {
+		return chain[:1]
+	}
+	return simple
+}
+
+func distance(a, b point) float64 {
+	return math.Hypot(float64(a.X-b.X), float64(a.Y-b.Y))
+}
+
+// arcLength returns the perimeter of a closed contour.
+func arcLength(contour []point) float64 {
+	var peri float64
+	for i := range contour {
+		peri += distance(contour[i], contour[(i+1)%len(contour)])
+	}
+	return peri
+}
+
+// distanceToLine returns the distance of p to the infinite line through a and b.
+func distanceToLine(p, a, b point) float64 {
+	dx, dy := float64(b.X-a.X), float64(b.Y-a.Y)
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return distance(p, a)
+	}
+	return math.Abs(dy*float64(p.X-a.X)-dx*float64(p.Y-a.Y)) / length
+}
+
+// approxPolyDP simplifies a closed contour with the Douglas-Peucker algorithm.
+func approxPolyDP(contour []point, epsilon float64) []point {
+	n := len(contour)
+	if n < 3 {
+		return contour
+	}
+	// split the closed curve at two far apart points, like opencv does
+	a, b := 0, 0
+	for iter := 0; iter < 3; iter++ {
+		var maxDist float64
+		for i, p := range contour {
+			if d := distance(contour[a], p); d > maxDist {
+				maxDist = d
+				b = i
+			}
+		}
+		if iter < 2 {
+			a, b = b, a
+		}
+	}
+	if a == b {
+		return contour[:1]
+	}
+
+	var keep func(i, j int) []int
+	keep = func(i, j int) []int {
+		// indices are taken modulo n, j may be larger than n
+		var maxDist float64
+		index := -1
+		for k := i + 1; k < j; k++ {
+			if d := distanceToLine(contour[k%n], contour[i%n], contour[j%n]); d > maxDist {
+				maxDist = d
+				index = k
+			}
+		}
+		if index < 0 || maxDist <= epsilon {
+			return []int{i}
+		}
+		return append(keep(i, index), keep(index, j)...)
+	}
+	if b < a {
+		b += n
+	}
+	indices := append(keep(a, b), keep(b, a+n)...)
+	approx := make([]point, len(indices))
+	for i, index := range indices {
+		approx[i] = contour[index%n]
+	}
+
+	// remove vertices lying on the line of their neighbours
+	for changed := true; changed && len(approx) > 3; {
+		changed = false
+		for i := range approx {
+			m := len(approx)
+			if distanceToLine(approx[i], approx[(i-1+m)%m], approx[(i+1)%m]) <= epsilon {
+				approx = append(approx[:i], approx[i+1:]...)
+				changed = true
+				break
+			}
+		}
+	}
+	return approx
+}
@@ -0,0 +1,344 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/nahrx/geomatis-api/types"
+)
+
+// testRaster is a white raster with black shapes, a shape is the ring between
+// an outer and an optional inner polygon.
+type testRaster struct {
+	width, height int
+	shapes        []testShape
+}
+
+type testShape struct {
+	outer, inner []types.Coord
+}
+
+func (r testRaster) image() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, r.width, r.height))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	for _, s := range r.shapes {
+		outer, inner := flatCoords(s.outer), flatCoords(s.inner)
+		for y := 0; y < r.height; y++ {
+			for x := 0; x < r.width; x++ {
+				cx, cy := float64(x)+0.5, float64(y)+0.5
+				if pointInRing(cx, cy, outer) && (inner == nil || !pointInRing(cx, cy, inner)) {
+					img.SetGray(x, y, color.Gray{})
+				}
+			}
+		}
+	}
+	return img
+}
+
+func flatCoords(coords []types.Coord) []float64 {
+	var flat []float64
+	for _, c := range coords {
+		flat = append(flat, c[0], c[1])
+	}
+	return flat
+}
+
+// frameShape is a frame line of the given thickness whose outer corners are corners.
+func frameShape(corners []types.Coord, thickness float64) testShape {
+	var cx, cy float64
+	for _, c := range corners {
+		cx, cy = cx+c[0]/4, cy+c[1]/4
+	}
+	inner := make([]types.Coord, len(corners))
+	for i, c := range corners {
+		// moves every corner towards the center by the thickness along both sides
+		dx, dy := cx-c[0], cy-c[1]
+		d := math.Hypot(dx, dy)
+		inner[i] = types.Coord{c[0] + dx/d*thickness*math.Sqrt2, c[1] + dy/d*thickness*math.Sqrt2}
+	}
+	return testShape{outer: corners, inner: inner}
+}
+
+func rectangle(minX, minY, maxX, maxY float64) []types.Coord {
+	return []types.Coord{{minX, minY}, {maxX, minY}, {maxX, maxY}, {minX, maxY}}
+}
+
+func rotated(corners []types.Coord, degrees float64) []types.Coord {
+	var cx, cy float64
+	for _, c := range corners {
+		cx, cy = cx+c[0]/4, cy+c[1]/4
+	}
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	out := make([]types.Coord, len(corners))
+	for i, c := range corners {
+		x, y := c[0]-cx, c[1]-cy
+		out[i] = types.Coord{cx + cos*x - sin*y, cy + sin*x + cos*y}
+	}
+	return out
+}
+
+func circle(cx, cy, r float64) []types.Coord {
+	var out []types.Coord
+	for i := 0; i < 64; i++ {
+		a := float64(i) * 2 * math.Pi / 64
+		out = append(out, types.Coord{cx + r*math.Cos(a), cy + r*math.Sin(a)})
+	}
+	return out
+}
+
+func writePng(t *testing.T, img image.Image) string {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), "raster.png")
+	file, err := os.Create(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+// writeJpegWithOrientation writes a jpeg with an EXIF APP1 segment holding only
+// the orientation tag.
+func writeJpegWithOrientation(t *testing.T, img image.Image, orientation uint16) string {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	var tiff bytes.Buffer
+	le := binary.LittleEndian
+	tiff.WriteString("II")
+	binary.Write(&tiff, le, uint16(42))
+	binary.Write(&tiff, le, uint32(8))
+	binary.Write(&tiff, le, uint16(1))
+	binary.Write(&tiff, le, []uint16{0x0112, 3})
+	binary.Write(&tiff, le, uint32(1))
+	binary.Write(&tiff, le, []uint16{orientation, 0})
+	binary.Write(&tiff, le, uint32(0))
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+
+	var out bytes.Buffer
+	out.Write(encoded.Bytes()[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(payload)+2))
+	out.Write(payload)
+	out.Write(encoded.Bytes()[2:])
+
+	filePath := filepath.Join(t.TempDir(), "raster.jpg")
+	if err := os.WriteFile(filePath, out.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+// matchCorners checks that every wanted corner has a detected point within
+// tolerance, whatever the order the contour is followed in.
+func matchCorners(t *testing.T, got, want []types.Coord, tolerance float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d points %v, want %d", len(got), got, len(want))
+	}
+	for _, w := range want {
+		best := math.Inf(1)
+		for _, g := range got {
+			best = math.Min(best, distanceCoord(g, w))
+		}
+		if best > tolerance {
+			t.Errorf("corner %v is %.1f pixels from the detected points %v", w, best, got)
+		}
+	}
+}
+
+func TestNativeFeatureDetector(t *testing.T) {
+	frame := rectangle(100, 100, 900, 700)
+	tilted := rotated(rectangle(200, 150, 800, 650), 12)
+	tests := []struct {
+		name    string
+		raster  testRaster
+		want    []types.Coord
+		wantErr bool
+	}{
+		{
+			name:   "plain rectangle",
+			raster: testRaster{1000, 800, []testShape{frameShape(frame, 10)}},
+			want:   frame,
+		},
+		{
+			name: "rectangle among text",
+			raster: testRaster{1000, 800, []testShape{
+				frameShape(frame, 10),
+				{outer: rectangle(20, 20, 300, 60)},
+				{outer: rectangle(600, 730, 980, 780)},
+				{outer: circle(500, 400, 60)},
+			}},
+			want: frame,
+		},
+		{
+			// like RETR_EXTERNAL, a closed sheet border hides the frame inside it
+			name: "rectangle inside a border",
+			raster: testRaster{1000, 800, []testShape{
+				frameShape(rectangle(30, 30, 970, 770), 5),
+				frameShape(frame, 10),
+			}},
+			want: rectangle(30, 30, 970, 770),
+		},
+		{
+			name:   "rotated frame",
+			raster: testRaster{1000, 800, []testShape{frameShape(tilted, 10)}},
+			want:   tilted,
+		},
+		{
+			name:    "no frame",
+			raster:  testRaster{1000, 800, []testShape{{outer: circle(500, 400, 200)}}},
+			wantErr: true,
+		},
+		{
+			name:    "blank",
+			raster:  testRaster{1000, 800, nil},
+			wantErr: true,
+		},
+	}
+	detector := NewNativeFeatureDetector()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := detector.GetRasterFeaturePoints(writePng(t, tt.raster.image()))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("points %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the raster is detected at 20%, one pixel there is 5 here
+			matchCorners(t, got, tt.want, 12)
+		})
+	}
+}
+
+func TestNativeFeatureDetectorOrientation(t *testing.T) {
+	// stored 1000x800, the frame is off center so a wrong rotation is caught
+	stored := rectangle(100, 100, 700, 500)
+	img := testRaster{1000, 800, []testShape{frameShape(stored, 10)}}.image()
+	tests := []struct {
+		orientation uint16
+		// the frame corners in the displayed raster
+		want []types.Coord
+	}{
+		{1, stored},
+		{3, rectangle(299, 299, 899, 699)},
+		// 90 degrees clockwise, displayed 800x1000
+		{6, rectangle(299, 100, 699, 700)},
+		// 90 degrees counterclockwise
+		{8, rectangle(100, 299, 500, 899)},
+	}
+	detector := NewNativeFeatureDetector()
+	for _, tt := range tests {
+		filePath := writeJpegWithOrientation(t, img, tt.orientation)
+		if got := rasterOrientation(filePath); got != int(tt.orientation) {
+			t.Fatalf("orientation %d read as %d", tt.orientation, got)
+		}
+		got, err := detector.GetRasterFeaturePoints(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		matchCorners(t, got, tt.want, 12)
+	}
+}
+
+func TestOrientPoint(t *testing.T) {
+	// the top right pixel of a 10x4 raster under every orientation
+	tests := []struct {
+		orientation int
+		want        types.Coord
+	}{
+		{1, types.Coord{9, 0}},
+		{2, types.Coord{0, 0}},
+		{3, types.Coord{0, 3}},
+		{4, types.Coord{9, 3}},
+		{5, types.Coord{0, 9}},
+		{6, types.Coord{3, 9}},
+		{7, types.Coord{3, 0}},
+		{8, types.Coord{0, 0}},
+	}
+	for _, tt := range tests {
+		if got := orientPoint(9, 0, 10, 4, tt.orientation); got[0] != tt.want[0] || got[1] != tt.want[1] {
+			t.Errorf("orientation %d : %v, want %v", tt.orientation, got, tt.want)
+		}
+	}
+}
+
+func TestApproxPolyDP(t *testing.T) {
+	// a closed square with extra points along its sides
+	contour := []point{{0, 0}, {5, 0}, {10, 0}, {10, 5}, {10, 10}, {5, 10}, {0, 10}, {0, 5}}
+	if got := approxPolyDP(contour, 0.015*arcLength(contour)); len(got) != 4 {
+		t.Errorf("square approximated by %v", got)
+	}
+	if got := arcLength(contour); got != 40 {
+		t.Errorf("arcLength = %v, want 40", got)
+	}
+}
+
+func TestThresholdOtsuInv(t *testing.T) {
+	gray := []uint8{10, 12, 15, 240, 250, 245, 11, 248}
+	want := []bool{true, true, true, false, false, false, true, false}
+	got := thresholdOtsuInv(gray)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("thresholdOtsuInv = %v, want %v", got, want)
+		}
+	}
+}
+
+// TestDetectorsAgree compares the native detector with the python (opencv)
+// path when python with cv2 is installed.
+func TestDetectorsAgree(t *testing.T) {
+	if err := exec.Command("python", "-c", "import cv2").Run(); err != nil {
+		t.Skip("python with cv2 is not available")
+	}
+	root, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	// pypy.py is imported from the working directory
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	rasters := []testRaster{
+		{1000, 800, []testShape{frameShape(rectangle(100, 100, 900, 700), 10)}},
+		{1000, 800, []testShape{frameShape(rotated(rectangle(200, 150, 800, 650), 12), 10)}},
+	}
+	native := NewNativeFeatureDetector()
+	python := &PythonFeatureDetector{}
+	for i, r := range rasters {
+		filePath := writePng(t, r.image())
+		want, err := python.GetRasterFeaturePoints(filePath)
+		if err != nil {
+			t.Fatalf("raster %d, python : %s", i, err)
+		}
+		got, err := native.GetRasterFeaturePoints(filePath)
+		if err != nil {
+			t.Fatalf("raster %d, native : %s", i, err)
+		}
+		// both detect at 20%, rounding may differ by one detection pixel
+		matchCorners(t, got, want, 5)
+	}
+}
@@ -0,0 +1,229 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+// DefaultSrid is WGS 84, the only CRS of RFC 7946 GeoJSON.
+const DefaultSrid = 4326
+
+var epsgCode = regexp.MustCompile(`EPSG:(?:[0-9.]*:)?([0-9]+)$`)
+
+// OpenGeoJSON checks a GeoJSON file and reads the SRID of its crs member, the
+// features are decoded later by Layer.Each, one at a time.
+func OpenGeoJSON(filePath string) (*Layer, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	srid, err := GeoJSONSrid(file)
+	if err != nil {
+		return nil, err
+	}
+	return &Layer{Srid: srid, path: filePath}, nil
+}
+
+// GeoJSONSrid reads the crs member of a feature collection, the features are
+// skipped token by token.
+func GeoJSONSrid(r io.Reader) (int, error) {
+	srid := DefaultSrid
+	err := walkFeatureCollection(json.NewDecoder(r), func(dec *json.Decoder, key string) error {
+		switch key {
+		case "crs":
+			var crs geojson.CRS
+			if err := dec.Decode(&crs); err != nil {
+				return err
+			}
+			var err error
+			srid, err = sridFromCRS(&crs)
+			if err != nil {
+				return err
+			}
+			return errStopWalk
+		case "features":
+			return skipValue(dec)
+		}
+		var skipped json.RawMessage
+		return dec.Decode(&skipped)
+	})
+	if err == errStopWalk {
+		err = nil
+	}
+	return srid, err
+}
+
+// DecodeGeoJSONFeatures calls fn for every feature of a feature collection
+// without holding the collection in memory.
+func DecodeGeoJSONFeatures(r io.Reader, fn func(*geojson.Feature) error) error {
+	return walkFeatureCollection(json.NewDecoder(r), func(dec *json.Decoder, key string) error {
+		if key != "features" {
+			var skipped json.RawMessage
+			return dec.Decode(&skipped)
+		}
+		if err := expectDelim(dec, '['); err != nil {
+			return err
+		}
+		for i := 0; dec.More(); i++ {
+			var feature geojson.Feature
+			if err := dec.Decode(&feature); err != nil {
+				return fmt.Errorf("Failed to decode feature %d. %s", i, err.Error())
+			}
+			if err := fn(&feature); err != nil {
+				return err
+			}
+		}
+		return expectDelim(dec, ']')
+	})
+}
+
+var errStopWalk = errors.New("stop walk")
+
+// walkFeatureCollection calls member for every top level member of the object,
+// member must consume the value.
+func walkFeatureCollection(dec *json.Decoder, member func(dec *json.Decoder, key string) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := token.(string)
+		if key == "type" {
+			var t string
+			if err := dec.Decode(&t); err != nil {
+				return err
+			}
+			if t != "FeatureCollection" {
+				return fmt.Errorf("GeoJSON type must be FeatureCollection, got %s", t)
+			}
+			continue
+		}
+		if err := member(dec, key); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("Invalid GeoJSON, expected %s but got %v", delim, token)
+	}
+	return nil
+}
+
+// skipValue consumes one value without keeping it.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// sridFromCRS reads the SRID from the deprecated crs member of a GeoJSON file,
+// e.g. "urn:ogc:def:crs:EPSG::32750" or "EPSG:32750".
+func sridFromCRS(crs *geojson.CRS) (int, error) {
+	switch crs.Type {
+	case "name":
+		name, _ := crs.Properties["name"].(string)
+		if strings.HasSuffix(name, "CRS84") || strings.HasSuffix(name, "CRS:84") {
+			return DefaultSrid, nil
+		}
+		if m := epsgCode.FindStringSubmatch(strings.ToUpper(name)); m != nil {
+			return strconv.Atoi(m[1])
+		}
+		return 0, fmt.Errorf("CRS %s is not supported, send the srid field instead", name)
+	case "EPSG":
+		// crs of the 2008 GeoJSON draft, {"type": "EPSG", "properties": {"code": 4326}}
+		if code, ok := crs.Properties["code"].(float64); ok {
+			return int(code), nil
+		}
+	}
+	return 0, fmt.Errorf("CRS type %s is not supported, send the srid field instead", crs.Type)
+}
+
+// GeoJSONCRS is the crs member of a layer in srid, nil for WGS 84.
+func GeoJSONCRS(srid int) *geojson.CRS {
+	if srid == DefaultSrid || srid == 0 {
+		return nil
+	}
+	return &geojson.CRS{
+		Type:       "name",
+		Properties: map[string]interface{}{"name": fmt.Sprintf("urn:ogc:def:crs:EPSG::%d", srid)},
+	}
+}
+
+// GeoJSONWriter streams a feature collection, one feature at a time.
+type GeoJSONWriter struct {
+	w       io.Writer
+	written int
+}
+
+// NewGeoJSONWriter writes the collection header, a crs member is added when the
+// features are not in WGS 84.
+func NewGeoJSONWriter(w io.Writer, name string, srid int) (*GeoJSONWriter, error) {
+	header := struct {
+		Type string       `json:"type"`
+		Name string       `json:"name"`
+		CRS  *geojson.CRS `json:"crs,omitempty"`
+	}{Type: "FeatureCollection", Name: name}
+	header.CRS = GeoJSONCRS(srid)
+	b, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	// reopen the object to append the features member
+	if _, err := w.Write(append(b[:len(b)-1], []byte(`,"features":[`)...)); err != nil {
+		return nil, err
+	}
+	return &GeoJSONWriter{w: w}, nil
+}
+
+func (g *GeoJSONWriter) Write(feature *geojson.Feature) error {
+	b, err := json.Marshal(feature)
+	if err != nil {
+		return err
+	}
+	if g.written > 0 {
+		b = append([]byte(","), b...)
+	}
+	if _, err := g.w.Write(b); err != nil {
+		return err
+	}
+	g.written++
+	return nil
+}
+
+// Close ends the collection, the underlying writer is left open.
+func (g *GeoJSONWriter) Close() error {
+	_, err := g.w.Write([]byte("]}\n"))
+	return err
+}
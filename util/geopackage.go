@@ -0,0 +1,364 @@
+package util
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nahrx/geomatis-api/types"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+	"github.com/twpayne/go-geom/encoding/wkb"
+	_ "modernc.org/sqlite"
+)
+
+// envelope sizes by the envelope indicator of the GeoPackage binary header
+var gpkgEnvelopeSize = []int{0, 32, 48, 48, 64}
+
+// ReadGeoPackage reads a feature table of a GeoPackage. layer picks the table
+// when the file holds more than one feature table.
+func ReadGeoPackage(filePath, layer string) (*Layer, error) {
+	db, err := sql.Open("sqlite", filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var layers []string
+	rows, err := db.Query(`SELECT table_name FROM gpkg_contents WHERE data_type = 'features'`)
+	if err != nil {
+		return nil, fmt.Errorf("Not a GeoPackage. %s", err.Error())
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		layers = append(layers, name)
+	}
+	rows.Close()
+
+	var table string
+	switch {
+	case len(layers) == 0:
+		return nil, fmt.Errorf("The GeoPackage does not contain a feature table")
+	case layer != "":
+		for _, l := range layers {
+			if strings.EqualFold(l, layer) {
+				table = l
+			}
+		}
+		if table == "" {
+			return nil, fmt.Errorf("Layer %s is not found in the GeoPackage, available layers : %s", layer, strings.Join(layers, ", "))
+		}
+	case len(layers) > 1:
+		return nil, fmt.Errorf("The GeoPackage contains more than one layer, choose one with the layer field : %s", strings.Join(layers, ", "))
+	default:
+		table = layers[0]
+	}
+
+	var geomColumn string
+	var srsId int
+	err = db.QueryRow(`SELECT column_name, srs_id FROM gpkg_geometry_columns WHERE table_name = ?`, table).Scan(&geomColumn, &srsId)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the geometry column of %s. %s", table, err.Error())
+	}
+	result := &Layer{Name: table}
+	var organization string
+	var coordsysId int
+	err = db.QueryRow(`SELECT organization, organization_coordsys_id, definition FROM gpkg_spatial_ref_sys WHERE srs_id = ?`, srsId).Scan(&organization, &coordsysId, &result.Prj)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the CRS of %s. %s", table, err.Error())
+	}
+	if strings.EqualFold(organization, "EPSG") {
+		result.Srid = coordsysId
+	} else {
+		result.Srid, _ = SridFromWkt(result.Prj)
+	}
+
+	// attribute columns, the integer primary key is the GeoPackage fid
+	var columns, declared []string
+	taken := map[string]bool{}
+	rows, err = db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, sqliteIdentifier(table)))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, dataType string
+		var defaultValue interface{}
+		if err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if pk > 0 || strings.EqualFold(name, geomColumn) {
+			continue
+		}
+		columns = append(columns, name)
+		taken[strings.ToLower(name)] = true
+		declared = append(declared, strings.ToUpper(dataType))
+	}
+	rows.Close()
+
+	// the properties renamed by geoPackageColumn on export get their name back
+	properties := make([]string, len(columns))
+	for i, c := range columns {
+		properties[i] = c
+		lower := strings.ToLower(c)
+		if (lower == "__fid" || lower == "__geom") && !taken[lower[2:]] {
+			properties[i] = c[2:]
+		}
+	}
+
+	selected := []string{sqliteIdentifier(geomColumn)}
+	for _, c := range columns {
+		selected = append(selected, sqliteIdentifier(c))
+	}
+	rows, err = db.Query(fmt.Sprintf(`SELECT %s FROM %s`, strings.Join(selected, ", "), sqliteIdentifier(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		values := make([]interface{}, len(selected))
+		pointers := make([]interface{}, len(selected))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		feature := &geojson.Feature{Properties: map[string]interface{}{}}
+		if blob, ok := values[0].([]byte); ok {
+			if feature.Geometry, err = decodeGeoPackageGeometry(blob); err != nil {
+				return nil, fmt.Errorf("Feature %d of %s. %s", len(result.Features)+1, table, err.Error())
+			}
+		}
+		for i, p := range properties {
+			feature.Properties[p] = geoPackageValue(values[i+1], declared[i])
+		}
+		result.Features = append(result.Features, feature)
+	}
+	return result, rows.Err()
+}
+
+// geoPackageValue converts a column to the value a GeoJSON decoder would give,
+// except integers which stay int64 so long ids keep every digit.
+func geoPackageValue(val interface{}, declared string) interface{} {
+	switch v := val.(type) {
+	case int64:
+		if declared == "BOOLEAN" {
+			return v != 0
+		}
+		return v
+	case float64, string, bool:
+		return v
+	case []byte:
+		if utf8.Valid(v) {
+			return string(v)
+		}
+		// binary blobs have no column type in the master map
+		return nil
+	case time.Time:
+		if declared == "DATE" {
+			return v.Format("2006-01-02")
+		}
+		return v.Format(time.RFC3339)
+	}
+	return nil
+}
+
+func decodeGeoPackageGeometry(blob []byte) (geom.T, error) {
+	if len(blob) < 8 || blob[0] != 'G' || blob[1] != 'P' {
+		return nil, fmt.Errorf("Geometry is not a GeoPackage binary")
+	}
+	flags := blob[3]
+	if flags&0x10 != 0 {
+		// empty geometry
+		return nil, nil
+	}
+	indicator := int(flags>>1) & 0x07
+	if indicator >= len(gpkgEnvelopeSize) || len(blob) < 8+gpkgEnvelopeSize[indicator] {
+		return nil, fmt.Errorf("Geometry has an invalid envelope")
+	}
+	g, err := wkb.Unmarshal(blob[8+gpkgEnvelopeSize[indicator]:])
+	if err != nil {
+		return nil, err
+	}
+	return force2D(g), nil
+}
+
+func encodeGeoPackageGeometry(g geom.T, srsId int) ([]byte, error) {
+	var b bytes.Buffer
+	bounds := g.Bounds()
+	// version 0, little endian, xy envelope
+	b.Write([]byte{'G', 'P', 0, 0x01 | 1<<1})
+	binary.Write(&b, binary.LittleEndian, int32(srsId))
+	for _, v := range []float64{bounds.Min(0), bounds.Max(0), bounds.Min(1), bounds.Max(1)} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	data, err := wkb.Marshal(g, binary.LittleEndian)
+	if err != nil {
+		return nil, err
+	}
+	b.Write(data)
+	return b.Bytes(), nil
+}
+
+func sqliteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// GeoPackageWriter writes one feature table into a new GeoPackage.
+type GeoPackageWriter struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	stmt    *sql.Stmt
+	schema  *types.MasterMapSchema
+	extent  *geom.Bounds
+	written int
+}
+
+// NewGeoPackageWriter creates the GeoPackage tables for the schema, wkt is the
+// definition of schema.Srid.
+func NewGeoPackageWriter(filePath string, schema *types.MasterMapSchema, wkt string) (*GeoPackageWriter, error) {
+	db, err := sql.Open("sqlite", filePath)
+	if err != nil {
+		return nil, err
+	}
+	// one connection, the pragmas and the transaction must see the same file state
+	db.SetMaxOpenConns(1)
+	w := &GeoPackageWriter{db: db, schema: schema, extent: geom.NewBounds(geom.XY)}
+	if err := w.createTables(wkt); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *GeoPackageWriter) createTables(wkt string) error {
+	geometryType := strings.ToUpper(w.schema.GeometryType)
+	table := sqliteIdentifier(w.schema.Name)
+	columns := []string{"fid INTEGER PRIMARY KEY AUTOINCREMENT", "geom " + geometryType}
+	for _, c := range w.schema.Columns {
+		columns = append(columns, sqliteIdentifier(geoPackageColumn(c))+" "+geoPackageType(c.Category))
+	}
+	statements := []string{
+		`PRAGMA application_id = 1196444487`,
+		`PRAGMA user_version = 10300`,
+		`CREATE TABLE gpkg_spatial_ref_sys (srs_name TEXT NOT NULL, srs_id INTEGER PRIMARY KEY, organization TEXT NOT NULL, organization_coordsys_id INTEGER NOT NULL, definition TEXT NOT NULL, description TEXT)`,
+		`CREATE TABLE gpkg_contents (table_name TEXT NOT NULL PRIMARY KEY, data_type TEXT NOT NULL, identifier TEXT UNIQUE, description TEXT DEFAULT '', last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')), min_x DOUBLE, min_y DOUBLE, max_x DOUBLE, max_y DOUBLE, srs_id INTEGER, CONSTRAINT fk_gc_r_srs_id FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id))`,
+		`CREATE TABLE gpkg_geometry_columns (table_name TEXT NOT NULL, column_name TEXT NOT NULL, geometry_type_name TEXT NOT NULL, srs_id INTEGER NOT NULL, z TINYINT NOT NULL, m TINYINT NOT NULL, CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name), CONSTRAINT fk_gc_tn FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name), CONSTRAINT fk_gc_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys (srs_id))`,
+		`INSERT INTO gpkg_spatial_ref_sys VALUES ('Undefined cartesian SRS', -1, 'NONE', -1, 'undefined', 'undefined cartesian coordinate reference system')`,
+		`INSERT INTO gpkg_spatial_ref_sys VALUES ('Undefined geographic SRS', 0, 'NONE', 0, 'undefined', 'undefined geographic coordinate reference system')`,
+		fmt.Sprintf(`CREATE TABLE %s (%s)`, table, strings.Join(columns, ", ")),
+	}
+	for _, statement := range statements {
+		if _, err := w.db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	// the spec requires the EPSG:4326 row next to -1 and 0, the layer CRS is
+	// added after it and replaces it when the layer is in 4326
+	if _, err := w.db.Exec(`INSERT INTO gpkg_spatial_ref_sys VALUES ('WGS 84 geodetic', 4326, 'EPSG', 4326, ?, 'longitude/latitude coordinates in decimal degrees on the WGS 84 spheroid')`, wktWgs84); err != nil {
+		return err
+	}
+	srsName := fmt.Sprintf("EPSG:%d", w.schema.Srid)
+	if _, err := w.db.Exec(`INSERT OR REPLACE INTO gpkg_spatial_ref_sys VALUES (?, ?, 'EPSG', ?, ?, '')`, srsName, w.schema.Srid, w.schema.Srid, wkt); err != nil {
+		return err
+	}
+	if _, err := w.db.Exec(`INSERT INTO gpkg_contents (table_name, data_type, identifier, srs_id) VALUES (?, 'features', ?, ?)`, w.schema.Name, w.schema.Name, w.schema.Srid); err != nil {
+		return err
+	}
+	if _, err := w.db.Exec(`INSERT INTO gpkg_geometry_columns VALUES (?, 'geom', ?, ?, 0, 0)`, w.schema.Name, geometryType, w.schema.Srid); err != nil {
+		return err
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(w.schema.Columns)+1), ", ")
+	names := []string{"geom"}
+	for _, c := range w.schema.Columns {
+		names = append(names, sqliteIdentifier(geoPackageColumn(c)))
+	}
+	var err error
+	if w.tx, err = w.db.Begin(); err != nil {
+		return err
+	}
+	w.stmt, err = w.tx.Prepare(fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`, table, strings.Join(names, ", "), placeholders))
+	return err
+}
+
+// geoPackageColumn keeps the property name unless it clashes with fid or geom.
+func geoPackageColumn(c types.MasterMapColumn) string {
+	if strings.EqualFold(c.Property, "fid") || strings.EqualFold(c.Property, "geom") {
+		return "__" + c.Property
+	}
+	return c.Property
+}
+
+// geoPackageType maps a master map column type to a GeoPackage data type.
+func geoPackageType(category string) string {
+	switch category {
+	case "integer", "bigint", "smallint":
+		return "INTEGER"
+	case "numeric", "double precision", "real":
+		return "DOUBLE"
+	case "boolean":
+		return "BOOLEAN"
+	}
+	return "TEXT"
+}
+
+// Write adds one feature, properties are looked up by their property name.
+func (w *GeoPackageWriter) Write(feature *geojson.Feature) error {
+	values := []interface{}{nil}
+	if feature.Geometry != nil {
+		blob, err := encodeGeoPackageGeometry(feature.Geometry, w.schema.Srid)
+		if err != nil {
+			return err
+		}
+		values[0] = blob
+		w.extent.Extend(feature.Geometry)
+	}
+	for _, c := range w.schema.Columns {
+		val := feature.Properties[c.Property]
+		switch v := val.(type) {
+		case json.RawMessage:
+			val = string(v)
+		case map[string]interface{}, []interface{}:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			val = string(b)
+		}
+		values = append(values, val)
+	}
+	if _, err := w.stmt.Exec(values...); err != nil {
+		return err
+	}
+	w.written++
+	return nil
+}
+
+// Close commits the features and stores the layer extent.
+func (w *GeoPackageWriter) Close() error {
+	defer w.db.Close()
+	w.stmt.Close()
+	if err := w.tx.Commit(); err != nil {
+		return err
+	}
+	if w.written > 0 && !w.extent.IsEmpty() {
+		_, err := w.db.Exec(`UPDATE gpkg_contents SET min_x = ?, min_y = ?, max_x = ?, max_y = ? WHERE table_name = ?`,
+			w.extent.Min(0), w.extent.Min(1), w.extent.Max(0), w.extent.Max(1), w.schema.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package util
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/nahrx/geomatis-api/types"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+func TestGeoPackageGeometry(t *testing.T) {
+	polygon := geom.NewPolygon(geom.XY).MustSetCoords([][]geom.Coord{{{0, 0}, {4, 0}, {4, 3}, {0, 0}}})
+	point := geom.NewPointFlat(geom.XYZ, []float64{1, 2, 3})
+	tests := []struct {
+		name string
+		g    geom.T
+		want []float64
+	}{
+		{"polygon", polygon, []float64{0, 0, 4, 0, 4, 3, 0, 0}},
+		{"z is dropped", point, []float64{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blob, err := encodeGeoPackageGeometry(tt.g, 4326)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := decodeGeoPackageGeometry(blob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Layout() != geom.XY || len(got.FlatCoords()) != len(tt.want) {
+				t.Fatalf("decoded %#v", got)
+			}
+			for i, v := range got.FlatCoords() {
+				if v != tt.want[i] {
+					t.Errorf("coordinate %d = %v, want %v", i, v, tt.want[i])
+				}
+			}
+		})
+	}
+
+	empty := []byte{'G', 'P', 0, 0x11, 0, 0, 0, 0}
+	if g, err := decodeGeoPackageGeometry(empty); g != nil || err != nil {
+		t.Errorf("empty geometry = %v, %v", g, err)
+	}
+	for name, blob := range map[string][]byte{
+		"not gpkg":           []byte("WKB00000"),
+		"short":              {'G', 'P'},
+		"invalid envelope":   {'G', 'P', 0, 0x0B, 0, 0, 0, 0},
+		"truncated envelope": {'G', 'P', 0, 0x03, 0, 0, 0, 0, 1},
+	} {
+		if _, err := decodeGeoPackageGeometry(blob); err == nil {
+			t.Errorf("%s must fail", name)
+		}
+	}
+}
+
+func TestGeoPackageRoundTrip(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "sls.gpkg")
+	schema := &types.MasterMapSchema{
+		Name:         "sls",
+		GeometryType: "MultiPolygon",
+		Srid:         4326,
+		Columns: []types.MasterMapColumn{
+			{Name: "idsls", Property: "idsls", Category: "text"},
+			{Name: "luas", Property: "luas", Category: "numeric"},
+			{Name: "jumlah", Property: "jumlah", Category: "integer"},
+			{Name: "aktif", Property: "aktif", Category: "boolean"},
+			{Name: "kode", Property: "kode", Category: "bigint"},
+			{Name: "__fid", Property: "fid", Category: "integer"},
+		},
+	}
+	w, err := NewGeoPackageWriter(filePath, schema, `GEOGCS["WGS 84"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multi := geom.NewMultiPolygon(geom.XY)
+	multi.Push(geom.NewPolygon(geom.XY).MustSetCoords([][]geom.Coord{{{106, -6}, {107, -6}, {107, -7}, {106, -6}}}))
+	features := []*geojson.Feature{
+		{Geometry: multi, Properties: map[string]interface{}{"idsls": "01", "luas": 1.5, "jumlah": 3.0, "aktif": true, "kode": int64(6471010001000123), "fid": 9.0}},
+		{Properties: map[string]interface{}{"idsls": "02"}},
+	}
+	for _, f := range features {
+		if err := w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	layer, err := ReadGeoPackage(filePath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer.Name != "sls" || layer.Srid != 4326 || layer.Prj != `GEOGCS["WGS 84"]` || len(layer.Features) != 2 {
+		t.Fatalf("layer %s, srid %d, prj %s, %d features", layer.Name, layer.Srid, layer.Prj, len(layer.Features))
+	}
+	first := layer.Features[0]
+	if g, ok := first.Geometry.(*geom.MultiPolygon); !ok || g.NumPolygons() != 1 {
+		t.Errorf("geometry = %#v", first.Geometry)
+	}
+	// integers keep every digit and the renamed fid gets its name back
+	want := map[string]interface{}{"idsls": "01", "luas": 1.5, "jumlah": int64(3), "aktif": true, "kode": int64(6471010001000123), "fid": int64(9)}
+	for k, v := range want {
+		if first.Properties[k] != v {
+			t.Errorf("property %s = %#v, want %#v", k, first.Properties[k], v)
+		}
+	}
+	second := layer.Features[1]
+	if second.Geometry != nil || second.Properties["idsls"] != "02" || second.Properties["luas"] != nil {
+		t.Errorf("feature 2 = %v, %v", second.Geometry, second.Properties)
+	}
+
+	if _, err := ReadGeoPackage(filePath, "desa"); err == nil {
+		t.Error("a missing layer must fail")
+	}
+}
+
+func TestGeoPackageSpatialRefSys(t *testing.T) {
+	for _, srid := range []int{4326, 32750} {
+		filePath := filepath.Join(t.TempDir(), "sls.gpkg")
+		schema := &types.MasterMapSchema{Name: "sls", GeometryType: "Point", Srid: srid}
+		w, err := NewGeoPackageWriter(filePath, schema, `LAYER CRS`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		db, err := sql.Open("sqlite", filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		definitions := map[int]string{}
+		rows, err := db.Query(`SELECT srs_id, definition FROM gpkg_spatial_ref_sys`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var id int
+			var definition string
+			if err := rows.Scan(&id, &definition); err != nil {
+				t.Fatal(err)
+			}
+			definitions[id] = definition
+		}
+		rows.Close()
+		db.Close()
+		// the rows the spec requires are always there
+		for _, id := range []int{4326, -1, 0} {
+			if _, ok := definitions[id]; !ok {
+				t.Errorf("srid %d : srs_id %d is missing", srid, id)
+			}
+		}
+		if definitions[srid] != `LAYER CRS` {
+			t.Errorf("srid %d : layer definition %q", srid, definitions[srid])
+		}
+		if srid != 4326 && definitions[4326] != wktWgs84 {
+			t.Errorf("srid %d : 4326 definition %q", srid, definitions[4326])
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/nahrx/geomatis-api/types"
+)
+
+// TIFF and GeoTIFF tags written by WriteGeoTiff.
+const (
+	tiffImageWidth          = 256
+	tiffImageLength         = 257
+	tiffBitsPerSample       = 258
+	tiffCompression         = 259
+	tiffPhotometric         = 262
+	tiffStripOffsets        = 273
+	tiffSamplesPerPixel     = 277
+	tiffRowsPerStrip        = 278
+	tiffStripByteCounts     = 279
+	tiffPlanarConfiguration = 284
+	tiffExtraSamples        = 338
+	tiffModelPixelScale     = 33550
+	tiffModelTiepoint       = 33922
+	tiffModelTransformation = 34264
+	tiffGeoKeyDirectory     = 34735
+
+	tiffShort  = 3
+	tiffLong   = 4
+	tiffDouble = 12
+
+	// geoTiffStripSize is the uncompressed size a strip is cut at
+	geoTiffStripSize = 256 * 1024
+)
+
+// GeoKeys of the GeoKeyDirectoryTag.
+const (
+	geoKeyModelType       = 1024
+	geoKeyRasterType      = 1025
+	geoKeyGeographicType  = 2048
+	geoKeyProjectedCSType = 3072
+
+	modelTypeProjected  = 1
+	modelTypeGeographic = 2
+	rasterPixelIsArea   = 1
+)
+
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+// WriteGeoTiff writes the raster as a deflate compressed RGBA (associated alpha) GeoTIFF georeferenced
+// with the world file parameters p in the CRS srid, wkt tells a geographic CRS from a
+// projected one. With northUp the raster is resampled so that rows run along the x
+// axis, the rotation terms of p are then dropped and the area outside the raster is
+// transparent.
+func WriteGeoTiff(filePath, rasterPath string, p types.WorldFileParameter, srid int, wkt string, northUp bool) error {
+	if srid <= 0 || srid > math.MaxUint16 {
+		return fmt.Errorf("SRID %v can not be written as a GeoTIFF EPSG code.", srid)
+	}
+	img, err := orientedRaster(rasterPath)
+	if err != nil {
+		return err
+	}
+	if northUp && (p.B != 0 || p.D != 0) {
+		img, p, err = resampleNorthUp(img, p)
+		if err != nil {
+			return err
+		}
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	rowsPerStrip := max(1, geoTiffStripSize/(4*width))
+	var strips [][]byte
+	for y := 0; y < height; y += rowsPerStrip {
+		rows := min(rowsPerStrip, height-y)
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		if _, err := zw.Write(img.Pix[img.PixOffset(0, y) : img.PixOffset(0, y)+4*width*rows]); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		strips = append(strips, buf.Bytes())
+	}
+
+	// the tiepoint and the transformation are anchored on the corner of the top
+	// left pixel (PixelIsArea) while the world file is anchored on its center
+	originX := p.C - p.A/2 - p.B/2
+	originY := p.F - p.D/2 - p.E/2
+	entries := []tiffEntry{
+		longEntry(tiffImageWidth, uint32(width)),
+		longEntry(tiffImageLength, uint32(height)),
+		shortEntry(tiffBitsPerSample, 8, 8, 8, 8),
+		shortEntry(tiffCompression, 8),
+		shortEntry(tiffPhotometric, 2),
+		longEntry(tiffStripOffsets, make([]uint32, len(strips))...),
+		shortEntry(tiffSamplesPerPixel, 4),
+		longEntry(tiffRowsPerStrip, uint32(rowsPerStrip)),
+		longEntry(tiffStripByteCounts, stripCounts(strips)...),
+		shortEntry(tiffPlanarConfiguration, 1),
+		shortEntry(tiffExtraSamples, 1),
+	}
+	if p.B == 0 && p.D == 0 {
+		entries = append(entries,
+			doubleEntry(tiffModelPixelScale, p.A, -p.E, 0),
+			doubleEntry(tiffModelTiepoint, 0, 0, 0, originX, originY, 0))
+	} else {
+		entries = append(entries, doubleEntry(tiffModelTransformation,
+			p.A, p.B, 0, originX,
+			p.D, p.E, 0, originY,
+			0, 0, 0, 0,
+			0, 0, 0, 1))
+	}
+	modelType, crsKey := uint16(modelTypeProjected), uint16(geoKeyProjectedCSType)
+	if isGeographicWkt(wkt) {
+		modelType, crsKey = modelTypeGeographic, geoKeyGeographicType
+	}
+	entries = append(entries, shortEntry(tiffGeoKeyDirectory,
+		1, 1, 0, 3,
+		geoKeyModelType, 0, 1, modelType,
+		geoKeyRasterType, 0, 1, rasterPixelIsArea,
+		crsKey, 0, 1, uint16(srid)))
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	// layout : header, strips, IFD, then the values that do not fit an entry
+	offset := uint32(8)
+	stripOffsets := make([]uint32, len(strips))
+	for i, s := range strips {
+		stripOffsets[i] = offset
+		offset += uint32(len(s))
+	}
+	padding := offset % 2
+	offset += padding
+	ifdOffset := offset
+	offset += uint32(2 + 12*len(entries) + 4)
+	for i := range entries {
+		if entries[i].tag == tiffStripOffsets {
+			entries[i] = longEntry(tiffStripOffsets, stripOffsets...)
+		}
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	le := binary.LittleEndian
+	w.WriteString("II")
+	binary.Write(w, le, uint16(42))
+	binary.Write(w, le, ifdOffset)
+	for _, s := range strips {
+		w.Write(s)
+	}
+	if padding == 1 {
+		w.WriteByte(0)
+	}
+	binary.Write(w, le, uint16(len(entries)))
+	var extra []byte
+	for _, e := range entries {
+		binary.Write(w, le, e.tag)
+		binary.Write(w, le, e.typ)
+		binary.Write(w, le, e.count)
+		if len(e.data) <= 4 {
+			value := make([]byte, 4)
+			copy(value, e.data)
+			w.Write(value)
+			continue
+		}
+		binary.Write(w, le, offset+uint32(len(extra)))
+		extra = append(extra, e.data...)
+		if len(extra)%2 == 1 {
+			extra = append(extra, 0)
+		}
+	}
+	binary.Write(w, le, uint32(0))
+	w.Write(extra)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// resampleNorthUp warps the raster to the bounding box of its map footprint with
+// square pixels of the same area, sampled bilinearly.
+func resampleNorthUp(img *image.RGBA, p types.WorldFileParameter) (*image.RGBA, types.WorldFileParameter, error) {
+	inverse, err := inverseWorldFile(p)
+	if err != nil {
+		return nil, p, err
+	}
+	width, height := float64(img.Bounds().Dx()), float64(img.Bounds().Dy())
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, c := range []types.Coord{{-0.5, -0.5}, {width - 0.5, -0.5}, {width - 0.5, height - 0.5}, {-0.5, height - 0.5}} {
+		x, y := ApplyWorldFileParameter(p, c)
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	size := math.Sqrt(math.Abs(p.A*p.E - p.B*p.D))
+	outW, outH := int(math.Ceil((maxX-minX)/size)), int(math.Ceil((maxY-minY)/size))
+	if outW <= 0 || outH <= 0 || outW*outH > 16*img.Bounds().Dx()*img.Bounds().Dy() {
+		return nil, p, fmt.Errorf("North up raster of %vx%v pixels is not valid.", outW, outH)
+	}
+	north := types.WorldFileParameter{A: size, E: -size, C: minX + size/2, F: maxY - size/2}
+	dst := image.NewRGBA(image.Rect(0, 0, outW, outH))
+	for y := 0; y < outH; y++ {
+		for x := 0; x < outW; x++ {
+			mx, my := ApplyWorldFileParameter(north, types.Coord{float64(x), float64(y)})
+			s := inverse(mx, my)
+			// transparent outside the raster, dst is zeroed
+			o := dst.PixOffset(x, y)
+			bilinearSample(img, s[0], s[1], dst.Pix[o:o+4])
+		}
+	}
+	return dst, north, nil
+}
+
+// isGeographicWkt tells a geographic CRS from a projected one by the WKT root.
+func isGeographicWkt(wkt string) bool {
+	root := strings.ToUpper(strings.TrimSpace(wkt))
+	return strings.HasPrefix(root, "GEOGCS") || strings.HasPrefix(root, "GEOGCRS") || strings.HasPrefix(root, "GEODCRS")
+}
+
+func stripCounts(strips [][]byte) []uint32 {
+	counts := make([]uint32, len(strips))
+	for i, s := range strips {
+		counts[i] = uint32(len(s))
+	}
+	return counts
+}
+
+func shortEntry(tag uint16, values ...uint16) tiffEntry {
+	data := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint16(data[2*i:], v)
+	}
+	return tiffEntry{tag: tag, typ: tiffShort, count: uint32(len(values)), data: data}
+}
+
+func longEntry(tag uint16, values ...uint32) tiffEntry {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(data[4*i:], v)
+	}
+	return tiffEntry{tag: tag, typ: tiffLong, count: uint32(len(values)), data: data}
+}
+
+func doubleEntry(tag uint16, values ...float64) tiffEntry {
+	data := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(data[8*i:], math.Float64bits(v))
+	}
+	return tiffEntry{tag: tag, typ: tiffDouble, count: uint32(len(values)), data: data}
+}
@@ -0,0 +1,85 @@
+package util
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+// Layer is a feature layer read from an uploaded file.
+type Layer struct {
+	Name     string
+	Features []*geojson.Feature
+	// Srid is 0 when the CRS of the file is not recognized
+	Srid int
+	// Prj is the WKT found in the file, if any
+	Prj string
+	// path of a GeoJSON file streamed by Each instead of Features
+	path string
+}
+
+// Each calls fn for every feature, a streamed layer is read again on each call.
+func (l *Layer) Each(fn func(*geojson.Feature) error) error {
+	if l.path == "" {
+		for _, feature := range l.Features {
+			if err := fn(feature); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return DecodeGeoJSONFeatures(bufio.NewReaderSize(file, 1<<20), fn)
+}
+
+// force2D drops the Z and M values, master map geometries are 2D.
+func force2D(g geom.T) geom.T {
+	if g == nil || g.Layout() == geom.XY {
+		return g
+	}
+	if gc, ok := g.(*geom.GeometryCollection); ok {
+		out := geom.NewGeometryCollection()
+		for _, child := range gc.Geoms() {
+			out.MustPush(force2D(child))
+		}
+		return out
+	}
+	stride := g.Stride()
+	flat := g.FlatCoords()
+	flat2D := make([]float64, 0, len(flat)/stride*2)
+	for i := 0; i+1 < len(flat); i += stride {
+		flat2D = append(flat2D, flat[i], flat[i+1])
+	}
+	ends := func(ends []int) []int {
+		out := make([]int, len(ends))
+		for i, end := range ends {
+			out[i] = end / stride * 2
+		}
+		return out
+	}
+	switch v := g.(type) {
+	case *geom.Point:
+		return geom.NewPointFlat(geom.XY, flat2D)
+	case *geom.LineString:
+		return geom.NewLineStringFlat(geom.XY, flat2D)
+	case *geom.Polygon:
+		return geom.NewPolygonFlat(geom.XY, flat2D, ends(v.Ends()))
+	case *geom.MultiPoint:
+		return geom.NewMultiPointFlat(geom.XY, flat2D)
+	case *geom.MultiLineString:
+		return geom.NewMultiLineStringFlat(geom.XY, flat2D, ends(v.Ends()))
+	case *geom.MultiPolygon:
+		endss := make([][]int, len(v.Endss()))
+		for i, e := range v.Endss() {
+			endss[i] = ends(e)
+		}
+		return geom.NewMultiPolygonFlat(geom.XY, flat2D, endss)
+	}
+	return g
+}
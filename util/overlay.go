@@ -0,0 +1,214 @@
+package util
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+
+	"github.com/nahrx/geomatis-api/types"
+	"github.com/twpayne/go-geom"
+)
+
+// overlayMaxSize is the longest side of a debug overlay in pixels.
+const overlayMaxSize = 1600
+
+// colors of the debug overlay
+var (
+	overlayFrameColor   = color.RGBA{230, 25, 75, 255}   // detected frame corners, red
+	overlayFeatureColor = color.RGBA{255, 165, 0, 255}   // margin adjusted feature box, orange
+	overlayPolygonColor = color.RGBA{0, 130, 200, 255}   // master map polygon, blue
+	overlayShadeColor   = color.NRGBA{255, 255, 255, 96} // lightens the sheet under the lines
+)
+
+// WriteDebugOverlay draws a downscaled copy of the raster with the detected frame
+// corners, the margin adjusted feature box and the master map polygon projected
+// back to pixels through the world file. polygon is in the CRS of the world file.
+func WriteDebugOverlay(filePath, rasterPath string, rasterPoints []types.Coord, fit *types.GeoreferenceFit, feature *types.RasterFeatureSettings, polygon geom.T) error {
+	file, err := os.Open(rasterPath)
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("Failed to decode raster. error : %s.", err.Error())
+	}
+	canvas, scale := orientedThumbnail(img, rasterOrientation(rasterPath), overlayMaxSize)
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(overlayShadeColor), image.Point{}, draw.Over)
+
+	toCanvas := func(pixel types.Coord) types.Coord {
+		return types.Coord{pixel[0] * scale, pixel[1] * scale}
+	}
+	toPixel, err := inverseWorldFile(fit.Parameter)
+	if err != nil {
+		return err
+	}
+
+	if polygon != nil {
+		for _, ring := range geometryRings(polygon) {
+			var line []types.Coord
+			for _, c := range ring {
+				line = append(line, toCanvas(toPixel(c[0], c[1])))
+			}
+			drawPolyline(canvas, line, overlayPolygonColor, 3)
+		}
+	}
+
+	var box []types.Coord
+	for _, c := range FeatureBox(fit, feature) {
+		box = append(box, toCanvas(toPixel(c[0], c[1])))
+	}
+	drawPolyline(canvas, append(box, box[0]), overlayFeatureColor, 3)
+
+	if len(rasterPoints) > 0 {
+		var frame []types.Coord
+		for _, p := range rasterPoints {
+			frame = append(frame, toCanvas(p))
+		}
+		drawPolyline(canvas, append(frame, frame[0]), overlayFrameColor, 3)
+		for _, p := range frame {
+			fillSquare(canvas, p, 11, overlayFrameColor)
+		}
+	}
+
+	out, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(out, canvas); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// FeatureBox returns the map corners of the frame minus its margins, the box the
+// feature extent was fitted in.
+func FeatureBox(fit *types.GeoreferenceFit, feature *types.RasterFeatureSettings) []types.Coord {
+	frame := types.Extent{MinX: math.Inf(1), MinY: math.Inf(1), MaxX: math.Inf(-1), MaxY: math.Inf(-1)}
+	for _, r := range fit.Residuals {
+		frame.MinX = math.Min(frame.MinX, r.Target[0])
+		frame.MinY = math.Min(frame.MinY, r.Target[1])
+		frame.MaxX = math.Max(frame.MaxX, r.Target[0])
+		frame.MaxY = math.Max(frame.MaxY, r.Target[1])
+	}
+	west, east, north, south := mapMargins(fit.Parameter, feature)
+	width := (frame.MaxX - frame.MinX) / (1 + west + east)
+	height := (frame.MaxY - frame.MinY) / (1 + north + south)
+	minX := frame.MinX + west*width
+	maxX := frame.MaxX - east*width
+	minY := frame.MinY + south*height
+	maxY := frame.MaxY - north*height
+	return []types.Coord{{minX, maxY}, {maxX, maxY}, {maxX, minY}, {minX, minY}}
+}
+
+// inverseWorldFile maps map coordinates back to pixels.
+func inverseWorldFile(p types.WorldFileParameter) (func(x, y float64) types.Coord, error) {
+	det := p.A*p.E - p.B*p.D
+	if math.Abs(det) < 1e-300 {
+		return nil, fmt.Errorf("World file parameters can not be inverted")
+	}
+	return func(x, y float64) types.Coord {
+		dx, dy := x-p.C, y-p.F
+		return types.Coord{(p.E*dx - p.B*dy) / det, (p.A*dy - p.D*dx) / det}
+	}, nil
+}
+
+// geometryRings lists the rings and lines of a geometry as flat coordinates.
+func geometryRings(g geom.T) [][]geom.Coord {
+	switch v := g.(type) {
+	case *geom.LineString:
+		return [][]geom.Coord{v.Coords()}
+	case *geom.Polygon:
+		return v.Coords()
+	case *geom.MultiLineString:
+		return v.Coords()
+	case *geom.MultiPolygon:
+		var rings [][]geom.Coord
+		for _, polygon := range v.Coords() {
+			rings = append(rings, polygon...)
+		}
+		return rings
+	case *geom.GeometryCollection:
+		var rings [][]geom.Coord
+		for _, child := range v.Geoms() {
+			rings = append(rings, geometryRings(child)...)
+		}
+		return rings
+	}
+	return nil
+}
+
+// orientedThumbnail downscales the raster to maxSize and applies the EXIF
+// orientation, so it shares the pixel space of the detected points. scale maps
+// raster pixels to thumbnail pixels.
+func orientedThumbnail(img image.Image, orientation int, maxSize int) (*image.RGBA, float64) {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	displayW, displayH := w, h
+	if orientation >= 5 && orientation <= 8 {
+		displayW, displayH = h, w
+	}
+	scale := math.Min(1, float64(maxSize)/float64(max(displayW, displayH)))
+	width := max(1, int(float64(displayW)*scale))
+	height := max(1, int(float64(displayH)*scale))
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// nearest sample of the displayed raster, mapped back to the stored one
+			dx := math.Min(float64(displayW-1), (float64(x)+0.5)/scale)
+			dy := math.Min(float64(displayH-1), (float64(y)+0.5)/scale)
+			sx, sy := storedPoint(int(dx), int(dy), w, h, orientation)
+			canvas.Set(x, y, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+	return canvas, scale
+}
+
+// storedPoint is the inverse of orientPoint.
+func storedPoint(x, y, w, h, orientation int) (int, int) {
+	switch orientation {
+	case 2:
+		return w - 1 - x, y
+	case 3:
+		return w - 1 - x, h - 1 - y
+	case 4:
+		return x, h - 1 - y
+	case 5:
+		return y, x
+	case 6:
+		return y, h - 1 - x
+	case 7:
+		return w - 1 - y, h - 1 - x
+	case 8:
+		return w - 1 - y, x
+	}
+	return x, y
+}
+
+func drawPolyline(img *image.RGBA, line []types.Coord, c color.RGBA, width int) {
+	for i := 1; i < len(line); i++ {
+		a, b := line[i-1], line[i]
+		steps := int(math.Ceil(math.Max(math.Abs(b[0]-a[0]), math.Abs(b[1]-a[1]))))
+		// lines far outside the canvas are not walked pixel by pixel
+		if steps > 4*(img.Bounds().Dx()+img.Bounds().Dy()) {
+			continue
+		}
+		for s := 0; s <= steps; s++ {
+			t := 0.0
+			if steps > 0 {
+				t = float64(s) / float64(steps)
+			}
+			fillSquare(img, types.Coord{a[0] + t*(b[0]-a[0]), a[1] + t*(b[1]-a[1])}, width, c)
+		}
+	}
+}
+
+func fillSquare(img *image.RGBA, p types.Coord, size int, c color.RGBA) {
+	x0, y0 := int(math.Round(p[0]))-size/2, int(math.Round(p[1]))-size/2
+	draw.Draw(img, image.Rect(x0, y0, x0+size, y0+size), image.NewUniform(c), image.Point{}, draw.Src)
+}
@@ -0,0 +1,237 @@
+package util
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/nahrx/geomatis-api/types"
+)
+
+const (
+	// PerspectiveTolerance is the distance between the midpoints of the two frame
+	// diagonals, as a share of the mean diagonal, above which the frame is not a
+	// parallelogram and no affine world file fits it
+	PerspectiveTolerance = 0.01
+	// rectifyMargin is how far around the rectified frame the raster is kept, as a
+	// share of the frame size
+	rectifyMargin = 0.5
+)
+
+// PerspectiveDistortion measures how far the four frame corners are from a
+// parallelogram. The diagonals of a parallelogram bisect each other, so the distance
+// between their midpoints is divided by the mean diagonal length. 0 is a
+// parallelogram, a phone photo taken at an angle is usually above 0.02.
+func PerspectiveDistortion(rasterPoints []types.Coord) (float64, error) {
+	d, err := FindDiagonalPoints(rasterPoints)
+	if err != nil {
+		return 0, err
+	}
+	mx := (d.TopLeft[0]+d.BottomRight[0])/2 - (d.TopRight[0]+d.BottomLeft[0])/2
+	my := (d.TopLeft[1]+d.BottomRight[1])/2 - (d.TopRight[1]+d.BottomLeft[1])/2
+	diagonal := (distanceCoord(d.TopLeft, d.BottomRight) + distanceCoord(d.TopRight, d.BottomLeft)) / 2
+	if diagonal == 0 {
+		return 0, fmt.Errorf("Frame corners are degenerate.")
+	}
+	return math.Hypot(mx, my) / diagonal, nil
+}
+
+// RectifyRaster warps the raster with the homography taking the four frame corners to
+// an upright rectangle of the mean side lengths and writes it to filePath, as jpeg or
+// png by its extension. The raster around the frame is kept up to rectifyMargin. It
+// returns the frame corners and the dimension of the rectified raster.
+func RectifyRaster(filePath, rasterPath string, rasterPoints []types.Coord) ([]types.Coord, types.Dimension, error) {
+	d, err := FindDiagonalPoints(rasterPoints)
+	if err != nil {
+		return nil, types.Dimension{}, err
+	}
+	src, err := orientedRaster(rasterPath)
+	if err != nil {
+		return nil, types.Dimension{}, err
+	}
+	displayW, displayH := src.Bounds().Dx(), src.Bounds().Dy()
+
+	width := (distanceCoord(d.TopLeft, d.TopRight) + distanceCoord(d.BottomLeft, d.BottomRight)) / 2
+	height := (distanceCoord(d.TopLeft, d.BottomLeft) + distanceCoord(d.TopRight, d.BottomRight)) / 2
+	quad := []types.Coord{d.TopLeft, d.TopRight, d.BottomRight, d.BottomLeft}
+	frame := []types.Coord{{0, 0}, {width, 0}, {width, height}, {0, height}}
+	h, err := homography(quad, frame)
+	if err != nil {
+		return nil, types.Dimension{}, err
+	}
+	inverse, err := homography(frame, quad)
+	if err != nil {
+		return nil, types.Dimension{}, err
+	}
+
+	// the warped raster corners may run far out (or behind the horizon), so the
+	// canvas is clipped to the frame and its margin
+	minX, minY := -rectifyMargin*width, -rectifyMargin*height
+	maxX, maxY := (1+rectifyMargin)*width, (1+rectifyMargin)*height
+	left, top, right, bottom := maxX, maxY, minX, minY
+	for _, c := range []types.Coord{{0, 0}, {float64(displayW), 0}, {float64(displayW), float64(displayH)}, {0, float64(displayH)}} {
+		x, y, ok := applyHomography(h, c[0], c[1])
+		if !ok {
+			left, top, right, bottom = minX, minY, maxX, maxY
+			break
+		}
+		left, right = math.Min(left, x), math.Max(right, x)
+		top, bottom = math.Min(top, y), math.Max(bottom, y)
+	}
+	left, top = math.Floor(math.Max(left, minX)), math.Floor(math.Max(top, minY))
+	right, bottom = math.Ceil(math.Min(right, maxX)), math.Ceil(math.Min(bottom, maxY))
+	outW, outH := int(right-left), int(bottom-top)
+	if outW <= 0 || outH <= 0 {
+		return nil, types.Dimension{}, fmt.Errorf("Rectified raster is empty.")
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, outW, outH))
+	for y := 0; y < outH; y++ {
+		for x := 0; x < outW; x++ {
+			o := dst.PixOffset(x, y)
+			fx, fy, ok := applyHomography(inverse, float64(x)+left, float64(y)+top)
+			if !ok || !bilinearSample(src, fx, fy, dst.Pix[o:o+4]) {
+				dst.Pix[o], dst.Pix[o+1], dst.Pix[o+2], dst.Pix[o+3] = 255, 255, 255, 255
+			}
+		}
+	}
+
+	out, err := os.Create(filePath)
+	if err != nil {
+		return nil, types.Dimension{}, err
+	}
+	defer out.Close()
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".jpg", ".jpeg":
+		err = jpeg.Encode(out, dst, &jpeg.Options{Quality: 95})
+	default:
+		err = png.Encode(out, dst)
+	}
+	if err != nil {
+		return nil, types.Dimension{}, fmt.Errorf("Failed to encode rectified raster. error : %s.", err.Error())
+	}
+	corners := make([]types.Coord, len(frame))
+	for i, c := range frame {
+		corners[i] = types.Coord{c[0] - left, c[1] - top}
+	}
+	return corners, types.Dimension{Length: float64(outW), Width: float64(outH)}, nil
+}
+
+// orientedRaster decodes the full raster with the EXIF orientation applied, in the
+// pixel space of the detected points.
+func orientedRaster(rasterPath string) (*image.RGBA, error) {
+	file, err := os.Open(rasterPath)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode raster. error : %s.", err.Error())
+	}
+	stored := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(stored, stored.Bounds(), img, img.Bounds().Min, draw.Src)
+	orientation := rasterOrientation(rasterPath)
+	if orientation <= 1 || orientation > 8 {
+		return stored, nil
+	}
+	w, h := stored.Bounds().Dx(), stored.Bounds().Dy()
+	displayW, displayH := w, h
+	if orientation >= 5 {
+		displayW, displayH = h, w
+	}
+	oriented := image.NewRGBA(image.Rect(0, 0, displayW, displayH))
+	for y := 0; y < displayH; y++ {
+		for x := 0; x < displayW; x++ {
+			sx, sy := storedPoint(x, y, w, h, orientation)
+			copy(oriented.Pix[oriented.PixOffset(x, y):][:4], stored.Pix[stored.PixOffset(sx, sy):][:4])
+		}
+	}
+	return oriented, nil
+}
+
+// bilinearSample writes the bilinear sample of img at (fx, fy) into dst, false when
+// the point is outside the raster.
+func bilinearSample(img *image.RGBA, fx, fy float64, dst []uint8) bool {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if fx < 0 || fy < 0 || fx > float64(w-1) || fy > float64(h-1) {
+		return false
+	}
+	x0, y0 := int(fx), int(fy)
+	x1, y1 := min(x0+1, w-1), min(y0+1, h-1)
+	tx, ty := fx-float64(x0), fy-float64(y0)
+	p00, p10 := img.Pix[img.PixOffset(x0, y0):], img.Pix[img.PixOffset(x1, y0):]
+	p01, p11 := img.Pix[img.PixOffset(x0, y1):], img.Pix[img.PixOffset(x1, y1):]
+	for c := 0; c < 4; c++ {
+		v := (1-ty)*((1-tx)*float64(p00[c])+tx*float64(p10[c])) + ty*((1-tx)*float64(p01[c])+tx*float64(p11[c]))
+		dst[c] = uint8(v + 0.5)
+	}
+	return true
+}
+
+// homography solves the 3x3 projective transform taking the four from points to the
+// four to points, with the last element fixed to 1.
+func homography(from, to []types.Coord) ([9]float64, error) {
+	m := make([][]float64, 8)
+	b := make([]float64, 8)
+	for i := 0; i < 4; i++ {
+		x, y, u, v := from[i][0], from[i][1], to[i][0], to[i][1]
+		m[2*i] = []float64{x, y, 1, 0, 0, 0, -u * x, -u * y}
+		m[2*i+1] = []float64{0, 0, 0, x, y, 1, -v * x, -v * y}
+		b[2*i], b[2*i+1] = u, v
+	}
+	h, err := solveLinear(m, b)
+	if err != nil {
+		return [9]float64{}, fmt.Errorf("Frame corners do not define a homography.")
+	}
+	return [9]float64{h[0], h[1], h[2], h[3], h[4], h[5], h[6], h[7], 1}, nil
+}
+
+// applyHomography maps a point, ok is false for points on or behind the horizon.
+func applyHomography(h [9]float64, x, y float64) (float64, float64, bool) {
+	w := h[6]*x + h[7]*y + h[8]
+	if w <= 1e-9 {
+		return 0, 0, false
+	}
+	return (h[0]*x + h[1]*y + h[2]) / w, (h[3]*x + h[4]*y + h[5]) / w, true
+}
+
+// solveLinear solves m x = b by gaussian elimination with partial pivoting.
+func solveLinear(m [][]float64, b []float64) ([]float64, error) {
+	n := len(b)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(m[r][col]) > math.Abs(m[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return nil, fmt.Errorf("singular system")
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		b[col], b[pivot] = b[pivot], b[col]
+		for r := col + 1; r < n; r++ {
+			f := m[r][col] / m[col][col]
+			for c := col; c < n; c++ {
+				m[r][c] -= f * m[col][c]
+			}
+			b[r] -= f * b[col]
+		}
+	}
+	x := make([]float64, n)
+	for r := n - 1; r >= 0; r-- {
+		sum := b[r]
+		for c := r + 1; c < n; c++ {
+			sum -= m[r][c] * x[c]
+		}
+		x[r] = sum / m[r][r]
+	}
+	return x, nil
+}
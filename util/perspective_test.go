@@ -0,0 +1,163 @@
+package util
+
+import (
+	"math"
+	"testing"
+
+	"github.com/nahrx/geomatis-api/types"
+)
+
+func TestHomography(t *testing.T) {
+	frame := []types.Coord{{0, 0}, {400, 0}, {400, 300}, {0, 300}}
+	tests := []struct {
+		name string
+		quad []types.Coord
+	}{
+		{"identity", frame},
+		{"affine", []types.Coord{{10, 20}, {410, 60}, {380, 360}, {-20, 320}}},
+		{"trapezoid", []types.Coord{{120, 100}, {880, 100}, {1000, 700}, {0, 700}}},
+		{"keystone", []types.Coord{{50, 40}, {700, 120}, {650, 520}, {90, 610}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := homography(tt.quad, frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inverse, err := homography(frame, tt.quad)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, p := range tt.quad {
+				x, y, ok := applyHomography(h, p[0], p[1])
+				if !ok || math.Hypot(x-frame[i][0], y-frame[i][1]) > 1e-6 {
+					t.Errorf("corner %v maps to (%v, %v), want %v", p, x, y, frame[i])
+				}
+			}
+			// a point inside the quad comes back to itself through the inverse
+			cx, cy := 0.0, 0.0
+			for _, p := range tt.quad {
+				cx, cy = cx+p[0]/4, cy+p[1]/4
+			}
+			x, y, ok := applyHomography(h, cx, cy)
+			if !ok {
+				t.Fatal("center is behind the horizon")
+			}
+			bx, by, ok := applyHomography(inverse, x, y)
+			if !ok || math.Hypot(bx-cx, by-cy) > 1e-6 {
+				t.Errorf("center (%v, %v) round trips to (%v, %v)", cx, cy, bx, by)
+			}
+		})
+	}
+
+	collinear := []types.Coord{{0, 0}, {1, 1}, {2, 2}, {3, 3}}
+	if _, err := homography(collinear, frame); err == nil {
+		t.Error("collinear corners must not define a homography")
+	}
+}
+
+func TestApplyHomographyHorizon(t *testing.T) {
+	// w = 1 - x/10 reaches 0 at x = 10
+	h := [9]float64{1, 0, 0, 0, 1, 0, -0.1, 0, 1}
+	if _, _, ok := applyHomography(h, 5, 0); !ok {
+		t.Error("a point before the horizon must map")
+	}
+	for _, x := range []float64{10, 20} {
+		if _, _, ok := applyHomography(h, x, 0); ok {
+			t.Errorf("x = %v is on or behind the horizon", x)
+		}
+	}
+}
+
+func TestSolveLinear(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       [][]float64
+		b       []float64
+		want    []float64
+		wantErr bool
+	}{
+		{
+			name: "3x3",
+			m:    [][]float64{{2, 1, -1}, {-3, -1, 2}, {-2, 1, 2}},
+			b:    []float64{8, -11, -3},
+			want: []float64{2, 3, -1},
+		},
+		{
+			name: "needs pivoting",
+			m:    [][]float64{{0, 1}, {1, 0}},
+			b:    []float64{4, 7},
+			want: []float64{7, 4},
+		},
+		{
+			name: "1x1",
+			m:    [][]float64{{4}},
+			b:    []float64{2},
+			want: []float64{0.5},
+		},
+		{
+			name:    "singular",
+			m:       [][]float64{{1, 2}, {2, 4}},
+			b:       []float64{3, 6},
+			wantErr: true,
+		},
+		{
+			name:    "zero",
+			m:       [][]float64{{0, 0}, {0, 0}},
+			b:       []float64{0, 0},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := solveLinear(tt.m, tt.b)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("solveLinear = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range tt.want {
+				if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+					t.Errorf("solveLinear = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestPerspectiveDistortion(t *testing.T) {
+	tests := []struct {
+		name     string
+		points   []types.Coord
+		min, max float64
+		wantErr  bool
+	}{
+		{name: "rectangle", points: []types.Coord{{100, 100}, {900, 100}, {900, 700}, {100, 700}}, max: 1e-9},
+		{name: "rotated parallelogram", points: []types.Coord{{100, 150}, {850, 100}, {900, 700}, {150, 750}}, max: 1e-9},
+		{name: "trapezoid", points: []types.Coord{{200, 100}, {800, 100}, {950, 700}, {50, 700}}, min: PerspectiveTolerance, max: 1},
+		{name: "degenerate", points: []types.Coord{{0, 0}, {0, 0}, {0, 0}, {0, 0}}, wantErr: true},
+		{name: "three points", points: []types.Coord{{0, 0}, {1, 0}, {1, 1}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PerspectiveDistortion(tt.points)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("PerspectiveDistortion = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got < tt.min || got > tt.max {
+				t.Errorf("PerspectiveDistortion = %v, want between %v and %v", got, tt.min, tt.max)
+			}
+		})
+	}
+}
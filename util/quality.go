@@ -0,0 +1,96 @@
+package util
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/nahrx/geomatis-api/types"
+)
+
+// Weights of every check in the quality score (weighted geometric mean), a check
+// scoring 0 rejects the georeference whatever the others are.
+const (
+	aspectRatioWeight = 0.25
+	areaShareWeight   = 0.35
+	residualWeight    = 0.4
+
+	// a map frame usually covers more than half of the scanned sheet
+	minAreaShare = 0.5
+	// residual RMSE, relative to the frame diagonal, that scores 0
+	maxResidual = 0.02
+	// checks scoring below this are listed as reasons
+	reasonScore = 0.5
+)
+
+// EvaluateGeoreferenceQuality compares the detected frame with the feature extent :
+// aspect ratio of the frame against the extent, share of the image covered by the
+// frame and the affine residuals of the fit.
+func EvaluateGeoreferenceQuality(img types.Dimension, rasterPoints []types.Coord, extent types.Extent, fit *types.GeoreferenceFit) (*types.Quality, error) {
+	// residuals are in map units, compare them in pixels with the frame size
+	rmsePixel := -1.0
+	pixelSize := math.Sqrt(math.Abs(fit.Parameter.A*fit.Parameter.E - fit.Parameter.B*fit.Parameter.D))
+	if pixelSize > 0 {
+		rmsePixel = fit.RMSE / pixelSize
+	}
+	return scoreQuality(img, rasterPoints, extent, rmsePixel, "Corner residual RMSE is %.1f pixels")
+}
+
+// EvaluateRefinedQuality scores a fit replaced by the outline refinement. The
+// refinement moves the corners away from the bounding box on purpose, so the
+// remaining outline distance is scored instead of the corner residuals.
+func EvaluateRefinedQuality(img types.Dimension, rasterPoints []types.Coord, extent types.Extent, refinement *types.Refinement) (*types.Quality, error) {
+	return scoreQuality(img, rasterPoints, extent, refinement.RefinedDistance, "Outline distance after refinement is %.1f pixels")
+}
+
+// scoreQuality scores the checks, residual is in raster pixels and negative when
+// it is not known.
+func scoreQuality(img types.Dimension, rasterPoints []types.Coord, extent types.Extent, residual float64, residualReason string) (*types.Quality, error) {
+	d, err := FindDiagonalPoints(rasterPoints)
+	if err != nil {
+		return nil, err
+	}
+	frame := types.Dimension{
+		Length: (distanceCoord(d.TopLeft, d.TopRight) + distanceCoord(d.BottomLeft, d.BottomRight)) / 2,
+		Width:  (distanceCoord(d.TopLeft, d.BottomLeft) + distanceCoord(d.TopRight, d.BottomRight)) / 2,
+	}
+	polygon := types.Dimension{
+		Length: extent.MaxX - extent.MinX,
+		Width:  extent.MaxY - extent.MinY,
+	}
+	var q types.Quality
+
+	frameRatio, polygonRatio := DimRatio(LW(frame)), DimRatio(LW(polygon))
+	if frameRatio > 0 && polygonRatio > 0 && !math.IsInf(polygonRatio, 0) {
+		q.AspectRatio = math.Min(frameRatio, polygonRatio) / math.Max(frameRatio, polygonRatio)
+	}
+	if q.AspectRatio < reasonScore {
+		q.Reasons = append(q.Reasons, fmt.Sprintf("Aspect ratio of the detected frame (%.2f) does not match the feature extent (%.2f)", frameRatio, polygonRatio))
+	}
+
+	share := polygonArea(rasterPoints) / (img.Length * img.Width)
+	q.AreaShare = math.Min(1, share/minAreaShare)
+	if q.AreaShare < reasonScore {
+		q.Reasons = append(q.Reasons, fmt.Sprintf("Detected frame covers only %.1f%% of the image", share*100))
+	}
+
+	if residual >= 0 {
+		relative := residual / math.Hypot(frame.Length, frame.Width)
+		q.Residual = math.Max(0, 1-relative/maxResidual)
+		if q.Residual < reasonScore {
+			q.Reasons = append(q.Reasons, fmt.Sprintf(residualReason, residual))
+		}
+	}
+
+	q.Score = math.Pow(q.AspectRatio, aspectRatioWeight) * math.Pow(q.AreaShare, areaShareWeight) * math.Pow(q.Residual, residualWeight)
+	return &q, nil
+}
+
+// polygonArea returns the area of a simple polygon (shoelace formula).
+func polygonArea(points []types.Coord) float64 {
+	var sum float64
+	for i := range points {
+		j := (i + 1) % len(points)
+		sum += points[i][0]*points[j][1] - points[j][0]*points[i][1]
+	}
+	return math.Abs(sum) / 2
+}
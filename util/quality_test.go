@@ -0,0 +1,80 @@
+package util
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/nahrx/geomatis-api/types"
+)
+
+func TestEvaluateQuality(t *testing.T) {
+	img := types.Dimension{Length: 1000, Width: 800}
+	// an 800x600 frame, diagonal 1000, covering 60% of the image
+	frame := []types.Coord{{100, 100}, {900, 100}, {900, 700}, {100, 700}}
+	extent := types.Extent{MinX: 0, MinY: 0, MaxX: 800, MaxY: 600}
+	feature := types.RasterFeatureSettings{XPosition: types.PositionCenter, YPosition: types.PositionMiddle}
+	fit, err := CalculateGeoreferenceParameters(img, frame, extent, &feature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a refinement moving the sheet by 20 map units, 2% of the frame diagonal
+	shifted := fit.Parameter
+	shifted.C += 20
+	refit := RefitParameter(fit, shifted)
+
+	tests := []struct {
+		name     string
+		quality  func() (*types.Quality, error)
+		residual float64
+		reason   string
+	}{
+		{
+			name:     "bounding box fit",
+			quality:  func() (*types.Quality, error) { return EvaluateGeoreferenceQuality(img, frame, extent, fit) },
+			residual: 1,
+		},
+		{
+			name:     "shifted corners",
+			quality:  func() (*types.Quality, error) { return EvaluateGeoreferenceQuality(img, frame, extent, refit) },
+			residual: 0,
+			reason:   "Corner residual",
+		},
+		{
+			name: "refined close to the outline",
+			quality: func() (*types.Quality, error) {
+				return EvaluateRefinedQuality(img, frame, extent, &types.Refinement{RefinedDistance: 5, Applied: true, Parameter: shifted})
+			},
+			residual: 0.75,
+		},
+		{
+			name: "refined far from the outline",
+			quality: func() (*types.Quality, error) {
+				return EvaluateRefinedQuality(img, frame, extent, &types.Refinement{RefinedDistance: 30, Applied: true, Parameter: shifted})
+			},
+			residual: 0,
+			reason:   "Outline distance",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := tt.quality()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(q.AspectRatio-1) > 1e-9 || q.AreaShare != 1 {
+				t.Errorf("aspect ratio %v, area share %v, want 1", q.AspectRatio, q.AreaShare)
+			}
+			if math.Abs(q.Residual-tt.residual) > 1e-9 {
+				t.Errorf("residual score %v, want %v", q.Residual, tt.residual)
+			}
+			if want := math.Pow(tt.residual, residualWeight); math.Abs(q.Score-want) > 1e-9 {
+				t.Errorf("score %v, want %v", q.Score, want)
+			}
+			reasons := strings.Join(q.Reasons, "; ")
+			if (tt.reason == "") != (reasons == "") || !strings.Contains(reasons, tt.reason) {
+				t.Errorf("reasons %q, want %q", reasons, tt.reason)
+			}
+		})
+	}
+}
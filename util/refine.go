@@ -0,0 +1,280 @@
+package util
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/nahrx/geomatis-api/types"
+	"github.com/twpayne/go-geom"
+)
+
+const (
+	// refineMaxSize is the longest side of the raster the outline is matched on
+	refineMaxSize = 1200
+	// refineSamples is the number of points taken along the polygon outline
+	refineSamples = 800
+	// refineMinImprovement is the share of the distance a refinement must remove
+	// to replace the bounding box solution
+	refineMinImprovement = 0.1
+	// refineFrameInset keeps the printed frame line out of the matched ink
+	refineFrameInset = 0.03
+)
+
+// RefineGeoreference matches the master map polygon outline against the ink of the
+// raster (truncated chamfer matching) starting from the bounding box fit. The
+// correction is a shift, scale and rotation of the outline in pixel space, found
+// with Nelder-Mead. polygon is in the CRS of the world file.
+func RefineGeoreference(rasterPath string, rasterPoints []types.Coord, fit *types.GeoreferenceFit, feature *types.RasterFeatureSettings, polygon geom.T) (*types.Refinement, error) {
+	file, err := os.Open(rasterPath)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode raster. error : %s.", err.Error())
+	}
+	thumb, scale := orientedThumbnail(img, rasterOrientation(rasterPath), refineMaxSize)
+	width, height := thumb.Bounds().Dx(), thumb.Bounds().Dy()
+	gray := make([]uint8, width*height)
+	for i := range gray {
+		r, g, b := thumb.Pix[4*i], thumb.Pix[4*i+1], thumb.Pix[4*i+2]
+		gray[i] = uint8(0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b))
+	}
+	ink := thresholdOtsuInv(gray)
+
+	// only the ink inside the frame, away from the frame line, is matched
+	frame := make([]float64, 0, 2*len(rasterPoints))
+	var cx, cy float64
+	for _, p := range rasterPoints {
+		cx += p[0] * scale / float64(len(rasterPoints))
+		cy += p[1] * scale / float64(len(rasterPoints))
+	}
+	for _, p := range rasterPoints {
+		frame = append(frame, cx+(p[0]*scale-cx)*(1-refineFrameInset), cy+(p[1]*scale-cy)*(1-refineFrameInset))
+	}
+	inked := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			if ink[i] && !pointInRing(float64(x), float64(y), frame) {
+				ink[i] = false
+			}
+			if ink[i] {
+				inked++
+			}
+		}
+	}
+	if inked == 0 {
+		return nil, fmt.Errorf("No ink found inside the frame")
+	}
+	dist := distanceTransform(ink, width, height)
+
+	toPixel, err := inverseWorldFile(fit.Parameter)
+	if err != nil {
+		return nil, err
+	}
+	samples := outlineSamples(polygon, refineSamples)
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("Polygon has no outline to match")
+	}
+	base := make([]types.Coord, len(samples))
+	center := types.Coord{0, 0}
+	for i, q := range samples {
+		p := toPixel(q[0], q[1])
+		base[i] = types.Coord{p[0] * scale, p[1] * scale}
+		center[0] += base[i][0] / float64(len(samples))
+		center[1] += base[i][1] / float64(len(samples))
+	}
+	diagonal := math.Hypot(float64(width), float64(height))
+	truncate := math.Max(3, 0.02*diagonal)
+
+	// v = shift x, shift y, log scale x, log scale y, rotation
+	transform := func(v []float64, p types.Coord) types.Coord {
+		sx, sy := math.Exp(v[2]), math.Exp(v[3])
+		sin, cos := math.Sincos(v[4])
+		x, y := (p[0]-center[0])*sx, (p[1]-center[1])*sy
+		return types.Coord{center[0] + cos*x - sin*y + v[0], center[1] + sin*x + cos*y + v[1]}
+	}
+	cost := func(v []float64) float64 {
+		if math.Hypot(v[0], v[1]) > 0.1*diagonal || math.Abs(v[2]) > 0.15 || math.Abs(v[3]) > 0.15 || math.Abs(v[4]) > 0.1 {
+			return 2 * truncate * truncate
+		}
+		var sum float64
+		for _, p := range base {
+			q := transform(v, p)
+			x, y := int(math.Round(q[0])), int(math.Round(q[1]))
+			d := truncate
+			if x >= 0 && y >= 0 && x < width && y < height {
+				d = math.Min(truncate, dist[y*width+x])
+			}
+			sum += d * d
+		}
+		return sum / float64(len(base))
+	}
+
+	start := []float64{0, 0, 0, 0, 0}
+	steps := []float64{0.02 * diagonal, 0.02 * diagonal, 0.02, 0.02, 0.01}
+	best, bestCost := nelderMead(cost, start, steps, 400)
+	initialCost := cost(start)
+
+	// the refined world file maps the corrected pixels of the feature box back
+	// to its map corners
+	box := FeatureBox(fit, feature)
+	var pixels []types.Coord
+	for _, c := range box {
+		p := toPixel(c[0], c[1])
+		q := transform(best, types.Coord{p[0] * scale, p[1] * scale})
+		pixels = append(pixels, types.Coord{q[0] / scale, q[1] / scale})
+	}
+	parameter, err := fitAffine(pixels, box)
+	if err != nil {
+		return nil, err
+	}
+
+	refinement := &types.Refinement{
+		InitialDistance: math.Sqrt(initialCost) / scale,
+		RefinedDistance: math.Sqrt(bestCost) / scale,
+		Scale:           math.Exp((best[2] + best[3]) / 2),
+		Rotation:        best[4] * 180 / math.Pi,
+		Parameter:       *parameter,
+	}
+	if initialCost > 0 {
+		refinement.Improvement = 1 - math.Sqrt(bestCost/initialCost)
+	}
+	pixelCenter := types.Coord{center[0] / scale, center[1] / scale}
+	oldX, oldY := ApplyWorldFileParameter(fit.Parameter, pixelCenter)
+	newX, newY := ApplyWorldFileParameter(*parameter, pixelCenter)
+	refinement.ShiftX, refinement.ShiftY = newX-oldX, newY-oldY
+	refinement.Applied = refinement.Improvement >= refineMinImprovement
+	return refinement, nil
+}
+
+// distanceTransform returns the distance of every pixel to the nearest set pixel,
+// approximated with the two pass 3-4 chamfer mask.
+func distanceTransform(set []bool, width, height int) []float64 {
+	const inf = math.MaxInt32 / 2
+	d := make([]int, len(set))
+	for i, v := range set {
+		if !v {
+			d[i] = inf
+		}
+	}
+	at := func(x, y int) int {
+		if x < 0 || y < 0 || x >= width || y >= height {
+			return inf
+		}
+		return d[y*width+x]
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			d[i] = min(d[i], at(x-1, y)+3, at(x, y-1)+3, at(x-1, y-1)+4, at(x+1, y-1)+4)
+		}
+	}
+	for y := height - 1; y >= 0; y-- {
+		for x := width - 1; x >= 0; x-- {
+			i := y*width + x
+			d[i] = min(d[i], at(x+1, y)+3, at(x, y+1)+3, at(x+1, y+1)+4, at(x-1, y+1)+4)
+		}
+	}
+	dist := make([]float64, len(d))
+	for i, v := range d {
+		dist[i] = float64(v) / 3
+	}
+	return dist
+}
+
+// outlineSamples takes about n points evenly spaced along the rings of a geometry.
+func outlineSamples(g geom.T, n int) []types.Coord {
+	rings := geometryRings(g)
+	var length float64
+	for _, ring := range rings {
+		for i := 1; i < len(ring); i++ {
+			length += math.Hypot(ring[i][0]-ring[i-1][0], ring[i][1]-ring[i-1][1])
+		}
+	}
+	if length == 0 {
+		return nil
+	}
+	step := length / float64(n)
+	var samples []types.Coord
+	for _, ring := range rings {
+		// next is the distance from the segment start to the next sample
+		next := 0.0
+		for i := 1; i < len(ring); i++ {
+			a, b := ring[i-1], ring[i]
+			segment := math.Hypot(b[0]-a[0], b[1]-a[1])
+			for ; next < segment; next += step {
+				f := next / segment
+				samples = append(samples, types.Coord{a[0] + f*(b[0]-a[0]), a[1] + f*(b[1]-a[1])})
+			}
+			next -= segment
+		}
+	}
+	return samples
+}
+
+// nelderMead minimizes f from start, steps size the initial simplex.
+func nelderMead(f func([]float64) float64, start, steps []float64, iterations int) ([]float64, float64) {
+	n := len(start)
+	type vertex struct {
+		x []float64
+		y float64
+	}
+	simplex := make([]vertex, n+1)
+	simplex[0] = vertex{append([]float64(nil), start...), f(start)}
+	for i := 0; i < n; i++ {
+		x := append([]float64(nil), start...)
+		x[i] += steps[i]
+		simplex[i+1] = vertex{x, f(x)}
+	}
+	along := func(from, to []float64, t float64) []float64 {
+		x := make([]float64, n)
+		for i := range x {
+			x[i] = from[i] + t*(to[i]-from[i])
+		}
+		return x
+	}
+	for it := 0; it < iterations; it++ {
+		sort.Slice(simplex, func(i, j int) bool { return simplex[i].y < simplex[j].y })
+		if simplex[n].y-simplex[0].y < 1e-9 {
+			break
+		}
+		centroid := make([]float64, n)
+		for _, v := range simplex[:n] {
+			for i := range centroid {
+				centroid[i] += v.x[i] / float64(n)
+			}
+		}
+		worst := simplex[n]
+		reflected := along(worst.x, centroid, 2)
+		ry := f(reflected)
+		switch {
+		case ry < simplex[0].y:
+			expanded := along(worst.x, centroid, 3)
+			if ey := f(expanded); ey < ry {
+				simplex[n] = vertex{expanded, ey}
+			} else {
+				simplex[n] = vertex{reflected, ry}
+			}
+		case ry < simplex[n-1].y:
+			simplex[n] = vertex{reflected, ry}
+		default:
+			contracted := along(worst.x, centroid, 0.5)
+			if cy := f(contracted); cy < worst.y {
+				simplex[n] = vertex{contracted, cy}
+				continue
+			}
+			for i := 1; i <= n; i++ {
+				x := along(simplex[0].x, simplex[i].x, 0.5)
+				simplex[i] = vertex{x, f(x)}
+			}
+		}
+	}
+	sort.Slice(simplex, func(i, j int) bool { return simplex[i].y < simplex[j].y })
+	return simplex[0].x, simplex[0].y
+}
@@ -0,0 +1,116 @@
+package util
+
+import (
+	"math"
+	"testing"
+
+	"github.com/twpayne/go-geom"
+)
+
+func TestNelderMead(t *testing.T) {
+	tests := []struct {
+		name  string
+		f     func([]float64) float64
+		start []float64
+		want  []float64
+	}{
+		{
+			name:  "quadratic",
+			f:     func(v []float64) float64 { return (v[0]-3)*(v[0]-3) + 2*(v[1]+1)*(v[1]+1) },
+			start: []float64{0, 0},
+			want:  []float64{3, -1},
+		},
+		{
+			name: "rosenbrock",
+			f: func(v []float64) float64 {
+				return (1-v[0])*(1-v[0]) + 100*(v[1]-v[0]*v[0])*(v[1]-v[0]*v[0])
+			},
+			start: []float64{-1, 1},
+			want:  []float64{1, 1},
+		},
+		{
+			name: "five parameters",
+			f: func(v []float64) float64 {
+				var sum float64
+				for i, x := range v {
+					sum += (x - float64(i)) * (x - float64(i))
+				}
+				return sum
+			},
+			start: []float64{1, 1, 1, 1, 1},
+			want:  []float64{0, 1, 2, 3, 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps := make([]float64, len(tt.start))
+			for i := range steps {
+				steps[i] = 0.5
+			}
+			got, y := nelderMead(tt.f, tt.start, steps, 2000)
+			for i := range tt.want {
+				if math.Abs(got[i]-tt.want[i]) > 1e-3 {
+					t.Errorf("minimum = %v (f %v), want %v", got, y, tt.want)
+					break
+				}
+			}
+			if y != tt.f(got) {
+				t.Errorf("returned value %v is not f(%v) = %v", y, got, tt.f(got))
+			}
+		})
+	}
+}
+
+func TestNelderMeadKeepsStart(t *testing.T) {
+	// the start is already the minimum, the simplex must not drift away
+	f := func(v []float64) float64 { return v[0]*v[0] + v[1]*v[1] }
+	got, y := nelderMead(f, []float64{0, 0}, []float64{1, 1}, 400)
+	if y > 1e-9 || math.Hypot(got[0], got[1]) > 1e-4 {
+		t.Errorf("minimum = %v (f %v), want the start", got, y)
+	}
+}
+
+func TestOutlineSamples(t *testing.T) {
+	square := geom.NewPolygon(geom.XY).MustSetCoords([][]geom.Coord{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}})
+	withHole := geom.NewPolygon(geom.XY).MustSetCoords([][]geom.Coord{
+		{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}},
+		{{4, 4}, {6, 4}, {6, 6}, {4, 6}, {4, 4}},
+	})
+	line := geom.NewLineString(geom.XY).MustSetCoords([]geom.Coord{{0, 0}, {3, 4}})
+	tests := []struct {
+		name string
+		g    geom.T
+		n    int
+		want int
+	}{
+		{"square", square, 40, 40},
+		{"polygon with a hole", withHole, 48, 48},
+		{"line", line, 5, 5},
+		{"empty", geom.NewPolygon(geom.XY), 10, 0},
+		{"point", geom.NewPointFlat(geom.XY, []float64{1, 1}), 10, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := outlineSamples(tt.g, tt.n)
+			// rounding may add or drop one sample per ring
+			if math.Abs(float64(len(samples)-tt.want)) > 2 {
+				t.Fatalf("%d samples, want %d", len(samples), tt.want)
+			}
+			bounds := tt.g.Bounds()
+			for _, s := range samples {
+				if s[0] < bounds.Min(0)-1e-9 || s[0] > bounds.Max(0)+1e-9 || s[1] < bounds.Min(1)-1e-9 || s[1] > bounds.Max(1)+1e-9 {
+					t.Errorf("sample %v is outside the geometry bounds", s)
+				}
+			}
+		})
+	}
+
+	// samples of the square are evenly spaced along its perimeter of 40
+	samples := outlineSamples(square, 40)
+	for i := 1; i < len(samples); i++ {
+		d := math.Abs(samples[i][0]-samples[i-1][0]) + math.Abs(samples[i][1]-samples[i-1][1])
+		if math.Abs(d-1) > 1e-9 {
+			t.Errorf("samples %v and %v are %v apart, want 1", samples[i-1], samples[i], d)
+		}
+	}
+}
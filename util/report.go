@@ -0,0 +1,79 @@
+package util
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/nahrx/geomatis-api/types"
+)
+
+// WriteGeoreferenceReport writes the per raster results as json or csv, chosen by
+// the extension of filePath.
+func WriteGeoreferenceReport(filePath string, results []types.Result) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".json":
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	case ".csv":
+		return writeReportCsv(csv.NewWriter(file), results)
+	}
+	return fmt.Errorf("Report extension %s is not valid. Only .json or .csv allowed.", path.Ext(filePath))
+}
+
+func writeReportCsv(w *csv.Writer, results []types.Result) error {
+	header := []string{"filename", "status", "raster_key", "feature", "master_map_version", "raster_path", "original_path", "world_file_path", "prj_path", "aux_xml_path", "overlay_path", "geotiff_path", "srid", "a", "d", "b", "e", "c", "f", "rmse", "perspective", "rectified", "refine_improvement", "refine_applied", "quality_score", "needs_review", "review_reason", "error_code", "error"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, r := range results {
+		parameter := make([]string, 6)
+		if p := r.Parameter; p != nil {
+			for i, v := range []float64{p.A, p.D, p.B, p.E, p.C, p.F} {
+				parameter[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		}
+		srid := ""
+		if r.Srid != 0 {
+			srid = strconv.Itoa(r.Srid)
+		}
+		row := []string{r.Filename, r.Status, r.RasterKey, r.Feature, r.MasterMapVersion, r.RasterPath, r.OriginalPath, r.WorldFilePath, r.PrjPath, r.AuxXmlPath, r.OverlayPath, r.GeoTiffPath, srid}
+		row = append(row, parameter...)
+		rmse := ""
+		if r.Parameter != nil {
+			rmse = strconv.FormatFloat(r.RMSE, 'f', -1, 64)
+		}
+		perspective, rectified := "", ""
+		if r.Parameter != nil {
+			perspective = strconv.FormatFloat(r.Perspective, 'f', 4, 64)
+			rectified = strconv.FormatBool(r.Rectified)
+		}
+		improvement, applied := "", ""
+		if r.Refinement != nil {
+			improvement = strconv.FormatFloat(r.Refinement.Improvement, 'f', 4, 64)
+			applied = strconv.FormatBool(r.Refinement.Applied)
+		}
+		score, reason := "", ""
+		if r.Quality != nil {
+			score = strconv.FormatFloat(r.Quality.Score, 'f', 4, 64)
+			reason = strings.Join(r.Quality.Reasons, "; ")
+		}
+		row = append(row, rmse, perspective, rectified, improvement, applied, score, strconv.FormatBool(r.NeedsReview), reason, r.ErrorCode, r.ErrorMessage)
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,505 @@
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// ReadShapefileZip reads the .shp, .dbf, .prj and .cpg of one layer in a zip.
+// layer picks the layer by name when the zip holds more than one .shp.
+// Z and M values are dropped, the master map geometries are 2D. maxEntrySize
+// limits the decompressed size of every file read from the zip.
+func ReadShapefileZip(r io.ReaderAt, size int64, layer string, maxEntrySize int64) (*Layer, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open zip. %s", err.Error())
+	}
+	files := map[string]*zip.File{}
+	var layers []string
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || strings.HasPrefix(path.Base(f.Name), "._") {
+			continue
+		}
+		ext := strings.ToLower(path.Ext(f.Name))
+		name := strings.TrimSuffix(f.Name, path.Ext(f.Name))
+		files[strings.ToLower(name)+ext] = f
+		if ext == ".shp" {
+			layers = append(layers, name)
+		}
+	}
+
+	var base string
+	switch {
+	case len(layers) == 0:
+		return nil, fmt.Errorf("The zip does not contain a .shp file")
+	case layer != "":
+		for _, l := range layers {
+			if strings.EqualFold(path.Base(l), layer) {
+				base = l
+			}
+		}
+		if base == "" {
+			return nil, fmt.Errorf("Layer %s is not found in the zip, available layers : %s", layer, layerNames(layers))
+		}
+	case len(layers) > 1:
+		return nil, fmt.Errorf("The zip contains more than one layer, choose one with the layer field : %s", layerNames(layers))
+	default:
+		base = layers[0]
+	}
+	key := strings.ToLower(base)
+
+	shp, err := readZipFile(files[key+".shp"], maxEntrySize)
+	if err != nil {
+		return nil, err
+	}
+	if files[key+".dbf"] == nil {
+		return nil, fmt.Errorf("%s.dbf is missing from the zip", path.Base(base))
+	}
+	dbf, err := readZipFile(files[key+".dbf"], maxEntrySize)
+	if err != nil {
+		return nil, err
+	}
+	var prj, cpg []byte
+	if f := files[key+".prj"]; f != nil {
+		if prj, err = readZipFile(f, maxEntrySize); err != nil {
+			return nil, err
+		}
+	}
+	if f := files[key+".cpg"]; f != nil {
+		if cpg, err = readZipFile(f, maxEntrySize); err != nil {
+			return nil, err
+		}
+	}
+
+	geometries, err := readShp(shp)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s.shp. %s", path.Base(base), err.Error())
+	}
+	records, deleted, err := readDbf(dbf, string(cpg))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s.dbf. %s", path.Base(base), err.Error())
+	}
+	if len(geometries) != len(records) {
+		return nil, fmt.Errorf("%s.shp has %d shapes but %s.dbf has %d records", path.Base(base), len(geometries), path.Base(base), len(records))
+	}
+
+	layerFile := &Layer{
+		Name: path.Base(base),
+		Prj:  strings.TrimSpace(string(prj)),
+	}
+	if layerFile.Prj != "" {
+		layerFile.Srid, _ = SridFromWkt(layerFile.Prj)
+	}
+	for i, g := range geometries {
+		if deleted[i] {
+			continue
+		}
+		layerFile.Features = append(layerFile.Features, &geojson.Feature{
+			Geometry:   g,
+			Properties: records[i],
+		})
+	}
+	return layerFile, nil
+}
+
+func layerNames(layers []string) string {
+	names := make([]string, len(layers))
+	for i, l := range layers {
+		names[i] = path.Base(l)
+	}
+	return strings.Join(names, ", ")
+}
+
+// readZipFile reads one file of the zip, failing once it decompresses to more
+// than maxSize bytes whatever size its header claims.
+func readZipFile(f *zip.File, maxSize int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(maxSize) {
+		return nil, fmt.Errorf("%s in the zip cannot be larger than %v", f.Name, maxSize)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s in the zip. %s", f.Name, err.Error())
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s in the zip. %s", f.Name, err.Error())
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%s in the zip cannot be larger than %v", f.Name, maxSize)
+	}
+	return data, nil
+}
+
+// shape types of the ESRI Shapefile technical description
+const (
+	shapeNull        = 0
+	shapePoint       = 1
+	shapePolyLine    = 3
+	shapePolygon     = 5
+	shapeMultiPoint  = 8
+	shapePointZ      = 11
+	shapePolyLineZ   = 13
+	shapePolygonZ    = 15
+	shapeMultiPointZ = 18
+	shapePointM      = 21
+	shapePolyLineM   = 23
+	shapePolygonM    = 25
+	shapeMultiPointM = 28
+)
+
+// readShp reads every record of a .shp, a null shape gives a nil geometry.
+func readShp(data []byte) ([]geom.T, error) {
+	if len(data) < 100 || binary.BigEndian.Uint32(data[0:4]) != 9994 {
+		return nil, fmt.Errorf("Not a shapefile")
+	}
+	var geometries []geom.T
+	for offset := 100; offset+8 <= len(data); {
+		contentLength := int(binary.BigEndian.Uint32(data[offset+4:offset+8])) * 2
+		offset += 8
+		if offset+contentLength > len(data) || contentLength < 4 {
+			return nil, fmt.Errorf("Record %d is truncated", len(geometries)+1)
+		}
+		g, err := readShape(data[offset : offset+contentLength])
+		if err != nil {
+			return nil, fmt.Errorf("Record %d. %s", len(geometries)+1, err.Error())
+		}
+		geometries = append(geometries, g)
+		offset += contentLength
+	}
+	return geometries, nil
+}
+
+func readShape(content []byte) (geom.T, error) {
+	shapeType := binary.LittleEndian.Uint32(content[0:4])
+	float := func(offset int) float64 {
+		return math.Float64frombits(binary.LittleEndian.Uint64(content[offset : offset+8]))
+	}
+	switch shapeType {
+	case shapeNull:
+		return nil, nil
+	case shapePoint, shapePointZ, shapePointM:
+		if len(content) < 20 {
+			return nil, fmt.Errorf("Point is truncated")
+		}
+		return geom.NewPointFlat(geom.XY, []float64{float(4), float(12)}), nil
+	case shapeMultiPoint, shapeMultiPointZ, shapeMultiPointM:
+		// type, bounding box, number of points, points
+		if len(content) < 40 {
+			return nil, fmt.Errorf("MultiPoint is truncated")
+		}
+		numPoints := int(binary.LittleEndian.Uint32(content[36:40]))
+		if len(content) < 40+numPoints*16 {
+			return nil, fmt.Errorf("MultiPoint is truncated")
+		}
+		flat := make([]float64, numPoints*2)
+		for i := range flat {
+			flat[i] = float(40 + i*8)
+		}
+		return geom.NewMultiPointFlat(geom.XY, flat), nil
+	case shapePolyLine, shapePolyLineZ, shapePolyLineM, shapePolygon, shapePolygonZ, shapePolygonM:
+		// type, bounding box, number of parts, number of points, parts, points
+		if len(content) < 44 {
+			return nil, fmt.Errorf("Shape is truncated")
+		}
+		numParts := int(binary.LittleEndian.Uint32(content[36:40]))
+		numPoints := int(binary.LittleEndian.Uint32(content[40:44]))
+		pointsOffset := 44 + numParts*4
+		if numParts == 0 || len(content) < pointsOffset+numPoints*16 {
+			return nil, fmt.Errorf("Shape is truncated")
+		}
+		flat := make([]float64, numPoints*2)
+		for i := range flat {
+			flat[i] = float(pointsOffset + i*8)
+		}
+		ends := make([]int, numParts)
+		for i := 0; i < numParts; i++ {
+			if i+1 < numParts {
+				ends[i] = int(binary.LittleEndian.Uint32(content[44+(i+1)*4:])) * 2
+			} else {
+				ends[i] = numPoints * 2
+			}
+			if ends[i] > len(flat) || (i > 0 && ends[i] < ends[i-1]) {
+				return nil, fmt.Errorf("Shape has invalid part index")
+			}
+		}
+		switch shapeType {
+		case shapePolygon, shapePolygonZ, shapePolygonM:
+			return polygonFromRings(flat, ends), nil
+		}
+		if numParts == 1 {
+			return geom.NewLineStringFlat(geom.XY, flat), nil
+		}
+		return geom.NewMultiLineStringFlat(geom.XY, flat, ends), nil
+	}
+	return nil, fmt.Errorf("Shape type %d is not supported", shapeType)
+}
+
+// polygonFromRings groups the rings of a shapefile polygon. Outer rings are
+// clockwise, holes are counterclockwise and belong to the outer ring holding them.
+func polygonFromRings(flat []float64, ends []int) geom.T {
+	type ring struct {
+		coords []float64
+		holes  [][]float64
+	}
+	var outers []*ring
+	var holes [][]float64
+	start := 0
+	for _, end := range ends {
+		coords := flat[start:end]
+		start = end
+		if len(coords) < 8 {
+			continue
+		}
+		if signedArea(coords) <= 0 {
+			outers = append(outers, &ring{coords: coords})
+		} else {
+			holes = append(holes, coords)
+		}
+	}
+	for _, hole := range holes {
+		var owner *ring
+		for _, outer := range outers {
+			if pointInRing(hole[0], hole[1], outer.coords) && (owner == nil || math.Abs(signedArea(outer.coords)) < math.Abs(signedArea(owner.coords))) {
+				owner = outer
+			}
+		}
+		if owner == nil {
+			// a hole outside every outer ring is taken as an outer ring
+			outers = append(outers, &ring{coords: hole})
+			continue
+		}
+		owner.holes = append(owner.holes, hole)
+	}
+
+	var multiFlat []float64
+	var endss [][]int
+	for _, outer := range outers {
+		var polygonEnds []int
+		for _, coords := range append([][]float64{outer.coords}, outer.holes...) {
+			multiFlat = append(multiFlat, coords...)
+			polygonEnds = append(polygonEnds, len(multiFlat))
+		}
+		endss = append(endss, polygonEnds)
+	}
+	if len(endss) == 1 {
+		return geom.NewPolygonFlat(geom.XY, multiFlat, endss[0])
+	}
+	return geom.NewMultiPolygonFlat(geom.XY, multiFlat, endss)
+}
+
+// signedArea is positive for a counterclockwise ring.
+func signedArea(coords []float64) float64 {
+	var sum float64
+	n := len(coords) / 2
+	for i := 0; i < n; i++ {
+		j := (i + 1) % n
+		sum += coords[2*i]*coords[2*j+1] - coords[2*j]*coords[2*i+1]
+	}
+	return sum / 2
+}
+
+func pointInRing(x, y float64, coords []float64) bool {
+	inside := false
+	n := len(coords) / 2
+	for i, j := 0, n-1; i < n; j, i = i, i+1 {
+		xi, yi := coords[2*i], coords[2*i+1]
+		xj, yj := coords[2*j], coords[2*j+1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+type dbfField struct {
+	name     string
+	kind     byte
+	length   int
+	decimals int
+}
+
+// readDbf reads the attribute table. Text is decoded with the code page of the
+// .cpg, or of the language driver byte when there is no .cpg.
+func readDbf(data []byte, cpg string) ([]map[string]interface{}, []bool, error) {
+	if len(data) < 32 {
+		return nil, nil, fmt.Errorf("Not a dBASE file")
+	}
+	numRecords := int(binary.LittleEndian.Uint32(data[4:8]))
+	headerLength := int(binary.LittleEndian.Uint16(data[8:10]))
+	recordLength := int(binary.LittleEndian.Uint16(data[10:12]))
+	decoder := dbfDecoder(cpg, data[29])
+	if headerLength > len(data) {
+		return nil, nil, fmt.Errorf("Header is truncated")
+	}
+	if recordLength < 1 {
+		return nil, nil, fmt.Errorf("Record length %d is not valid", recordLength)
+	}
+
+	var fields []dbfField
+	for offset := 32; offset+32 <= headerLength && data[offset] != 0x0D; offset += 32 {
+		descriptor := data[offset : offset+32]
+		name := descriptor[:11]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		fields = append(fields, dbfField{
+			name:     strings.TrimSpace(decodeText(decoder, name)),
+			kind:     descriptor[11],
+			length:   int(descriptor[16]),
+			decimals: int(descriptor[17]),
+		})
+	}
+
+	// the record count comes from the file, no more records than the data holds are allocated
+	capacity := min(numRecords, (len(data)-headerLength)/recordLength)
+	records := make([]map[string]interface{}, 0, capacity)
+	deleted := make([]bool, 0, capacity)
+	for i := 0; i < numRecords; i++ {
+		offset := headerLength + i*recordLength
+		if offset+recordLength > len(data) {
+			return nil, nil, fmt.Errorf("Record %d is truncated", i+1)
+		}
+		record := data[offset : offset+recordLength]
+		deleted = append(deleted, record[0] == '*')
+		values := map[string]interface{}{}
+		position := 1
+		for _, field := range fields {
+			if position+field.length > len(record) {
+				return nil, nil, fmt.Errorf("Field %s of record %d is truncated", field.name, i+1)
+			}
+			values[field.name] = dbfValue(field, record[position:position+field.length], decoder)
+			position += field.length
+		}
+		records = append(records, values)
+	}
+	return records, deleted, nil
+}
+
+// dbfValue converts a field to the value a GeoJSON decoder would give, numbers
+// are float64 so the schema inference treats both formats the same.
+func dbfValue(field dbfField, raw []byte, decoder *encoding.Decoder) interface{} {
+	switch field.kind {
+	case 'N', 'F':
+		text := strings.TrimSpace(string(raw))
+		if text == "" || strings.Trim(text, "*") == "" {
+			return nil
+		}
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil
+		}
+		return v
+	case 'I':
+		if len(raw) < 4 {
+			return nil
+		}
+		return float64(int32(binary.LittleEndian.Uint32(raw)))
+	case 'L':
+		switch strings.TrimSpace(string(raw)) {
+		case "T", "t", "Y", "y":
+			return true
+		case "F", "f", "N", "n":
+			return false
+		}
+		return nil
+	case 'D':
+		text := strings.TrimSpace(string(raw))
+		if len(text) != 8 || strings.Trim(text, "0") == "" {
+			return nil
+		}
+		return text[0:4] + "-" + text[4:6] + "-" + text[6:8]
+	case 'M':
+		// memo blocks live in a .dbt, not part of a shapefile
+		return nil
+	}
+	text := strings.TrimSpace(decodeText(decoder, bytes.TrimRight(raw, "\x00")))
+	if text == "" {
+		return nil
+	}
+	return text
+}
+
+// code pages by the names found in .cpg files
+var cpgEncodings = map[string]encoding.Encoding{
+	"utf8":        unicode.UTF8,
+	"65001":       unicode.UTF8,
+	"437":         charmap.CodePage437,
+	"850":         charmap.CodePage850,
+	"852":         charmap.CodePage852,
+	"866":         charmap.CodePage866,
+	"1250":        charmap.Windows1250,
+	"1251":        charmap.Windows1251,
+	"1252":        charmap.Windows1252,
+	"1253":        charmap.Windows1253,
+	"1254":        charmap.Windows1254,
+	"1257":        charmap.Windows1257,
+	"88591":       charmap.ISO8859_1,
+	"iso88591":    charmap.ISO8859_1,
+	"88592":       charmap.ISO8859_2,
+	"iso88592":    charmap.ISO8859_2,
+	"885915":      charmap.ISO8859_15,
+	"iso885915":   charmap.ISO8859_15,
+	"latin1":      charmap.ISO8859_1,
+	"windows1252": charmap.Windows1252,
+}
+
+// code pages by the language driver id at byte 29 of the dBASE header
+var ldidEncodings = map[byte]encoding.Encoding{
+	0x01: charmap.CodePage437,
+	0x02: charmap.CodePage850,
+	0x03: charmap.Windows1252,
+	0x57: charmap.Windows1252,
+	0x58: charmap.Windows1252,
+	0x64: charmap.CodePage852,
+	0x65: charmap.CodePage866,
+	0xC8: charmap.Windows1250,
+	0xC9: charmap.Windows1251,
+	0xCA: charmap.Windows1254,
+	0xCB: charmap.Windows1253,
+	0xCC: charmap.Windows1257,
+}
+
+// dbfDecoder returns nil when the code page is unknown, see decodeText.
+func dbfDecoder(cpg string, ldid byte) *encoding.Decoder {
+	name := strings.ToLower(strings.TrimSpace(cpg))
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "ansi"), "cp")
+	name = strings.NewReplacer("-", "", "_", "", " ", "").Replace(name)
+	if e, ok := cpgEncodings[name]; ok {
+		return e.NewDecoder()
+	}
+	if e, ok := ldidEncodings[ldid]; ok {
+		return e.NewDecoder()
+	}
+	return nil
+}
+
+// decodeText decodes with the code page, without one valid UTF-8 is kept and
+// anything else is read as Windows-1252.
+func decodeText(decoder *encoding.Decoder, raw []byte) string {
+	if decoder == nil {
+		if utf8.Valid(raw) {
+			return string(raw)
+		}
+		decoder = charmap.Windows1252.NewDecoder()
+	}
+	text, err := decoder.Bytes(raw)
+	if err != nil {
+		return string(raw)
+	}
+	return string(text)
+}
@@ -0,0 +1,288 @@
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/twpayne/go-geom"
+)
+
+type testDbfField struct {
+	name   string
+	kind   byte
+	length int
+}
+
+// testDbf builds a dBASE III table, a leading '*' in a record marks it deleted.
+func testDbf(fields []testDbfField, records [][]string, deleted []bool) []byte {
+	headerLength := 32 + 32*len(fields) + 1
+	recordLength := 1
+	for _, f := range fields {
+		recordLength += f.length
+	}
+	data := make([]byte, headerLength)
+	data[0] = 0x03
+	binary.LittleEndian.PutUint32(data[4:8], uint32(len(records)))
+	binary.LittleEndian.PutUint16(data[8:10], uint16(headerLength))
+	binary.LittleEndian.PutUint16(data[10:12], uint16(recordLength))
+	for i, f := range fields {
+		descriptor := data[32+32*i:]
+		copy(descriptor[:11], f.name)
+		descriptor[11] = f.kind
+		descriptor[16] = byte(f.length)
+	}
+	data[headerLength-1] = 0x0D
+	for i, record := range records {
+		flag := byte(' ')
+		if deleted != nil && deleted[i] {
+			flag = '*'
+		}
+		data = append(data, flag)
+		for j, f := range fields {
+			value := make([]byte, f.length)
+			for k := range value {
+				value[k] = ' '
+			}
+			copy(value, record[j])
+			data = append(data, value...)
+		}
+	}
+	return append(data, 0x1A)
+}
+
+// testShp builds a .shp of single ring polygons.
+func testShp(rings [][]float64) []byte {
+	data := make([]byte, 100)
+	binary.BigEndian.PutUint32(data[0:4], 9994)
+	binary.LittleEndian.PutUint32(data[28:32], 1000)
+	binary.LittleEndian.PutUint32(data[32:36], shapePolygon)
+	for i, ring := range rings {
+		content := make([]byte, 48+len(ring)*8)
+		binary.LittleEndian.PutUint32(content[0:4], shapePolygon)
+		binary.LittleEndian.PutUint32(content[36:40], 1)
+		binary.LittleEndian.PutUint32(content[40:44], uint32(len(ring)/2))
+		for j, v := range ring {
+			binary.LittleEndian.PutUint64(content[48+j*8:], math.Float64bits(v))
+		}
+		header := make([]byte, 8)
+		binary.BigEndian.PutUint32(header[0:4], uint32(i+1))
+		binary.BigEndian.PutUint32(header[4:8], uint32(len(content)/2))
+		data = append(data, header...)
+		data = append(data, content...)
+	}
+	binary.BigEndian.PutUint32(data[24:28], uint32(len(data)/2))
+	return data
+}
+
+func TestReadDbf(t *testing.T) {
+	fields := []testDbfField{{"IDSLS", 'C', 10}, {"LUAS", 'N', 8}, {"AKTIF", 'L', 1}}
+	valid := testDbf(fields, [][]string{{"6471010001", "12.5", "T"}, {"6471010002", "", "F"}}, []bool{false, true})
+
+	zeroLength := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint16(zeroLength[10:12], 0)
+	hugeCount := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint32(hugeCount[4:8], math.MaxUint32)
+	truncated := valid[:len(valid)-10]
+	shortHeader := append([]byte(nil), valid[:40]...)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		records int
+		wantErr bool
+	}{
+		{name: "valid", data: valid, records: 2},
+		{name: "empty", data: testDbf(fields, nil, nil), records: 0},
+		{name: "not dbase", data: []byte("dbf"), wantErr: true},
+		{name: "zero record length", data: zeroLength, wantErr: true},
+		{name: "huge record count", data: hugeCount, wantErr: true},
+		{name: "truncated record", data: truncated, wantErr: true},
+		{name: "truncated header", data: shortHeader, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, deleted, err := readDbf(tt.data, "")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("%d records, want an error", len(records))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != tt.records || len(deleted) != tt.records {
+				t.Fatalf("%d records, %d deleted flags, want %d", len(records), len(deleted), tt.records)
+			}
+		})
+	}
+
+	records, deleted, _ := readDbf(valid, "")
+	if records[0]["IDSLS"] != "6471010001" || records[0]["LUAS"] != 12.5 || records[0]["AKTIF"] != true {
+		t.Errorf("record 1 = %v", records[0])
+	}
+	if records[1]["LUAS"] != nil || records[1]["AKTIF"] != false {
+		t.Errorf("record 2 = %v", records[1])
+	}
+	if deleted[0] || !deleted[1] {
+		t.Errorf("deleted = %v", deleted)
+	}
+}
+
+func TestReadDbfCodePage(t *testing.T) {
+	// 0xE9 is é in windows-1252 and an invalid byte in utf-8
+	data := testDbf([]testDbfField{{"NAMA", 'C', 8}}, [][]string{{"caf\xe9"}}, nil)
+	tests := []struct {
+		cpg, want string
+	}{
+		{"1252", "café"},
+		{"UTF-8", "caf�"},
+	}
+	for _, tt := range tests {
+		records, _, err := readDbf(data, tt.cpg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := records[0]["NAMA"]; got != tt.want {
+			t.Errorf("cpg %s : %q, want %q", tt.cpg, got, tt.want)
+		}
+	}
+}
+
+func TestReadShp(t *testing.T) {
+	square := []float64{0, 0, 0, 10, 10, 10, 10, 0, 0, 0}
+	data := testShp([][]float64{square})
+	geometries, err := readShp(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(geometries) != 1 {
+		t.Fatalf("%d geometries, want 1", len(geometries))
+	}
+	polygon, ok := geometries[0].(*geom.Polygon)
+	if !ok || polygon.NumLinearRings() != 1 || math.Abs(polygon.Area()) != 100 {
+		t.Errorf("geometry = %#v", geometries[0])
+	}
+
+	if _, err := readShp(data[:len(data)-8]); err == nil {
+		t.Error("a truncated record must fail")
+	}
+	if _, err := readShp(make([]byte, 100)); err == nil {
+		t.Error("a file without the shapefile code must fail")
+	}
+}
+
+func TestPolygonFromRings(t *testing.T) {
+	// outer rings are clockwise, holes counterclockwise
+	outer := []float64{0, 0, 0, 10, 10, 10, 10, 0, 0, 0}
+	hole := []float64{2, 2, 4, 2, 4, 4, 2, 4, 2, 2}
+	other := []float64{20, 0, 20, 5, 25, 5, 25, 0, 20, 0}
+
+	flat := append(append([]float64{}, outer...), hole...)
+	g := polygonFromRings(flat, []int{len(outer), len(flat)})
+	if p, ok := g.(*geom.Polygon); !ok || p.NumLinearRings() != 2 || p.LinearRing(1).NumCoords() != 5 {
+		t.Errorf("polygon with a hole = %#v", g)
+	}
+
+	flat = append(append([]float64{}, outer...), other...)
+	g = polygonFromRings(flat, []int{len(outer), len(flat)})
+	if mp, ok := g.(*geom.MultiPolygon); !ok || mp.NumPolygons() != 2 || mp.Polygon(1).NumLinearRings() != 1 {
+		t.Errorf("two outer rings = %#v", g)
+	}
+}
+
+func TestReadShapefileZip(t *testing.T) {
+	square := []float64{0, 0, 0, 10, 10, 10, 10, 0, 0, 0}
+	fields := []testDbfField{{"IDSLS", 'C', 10}}
+	dbf := testDbf(fields, [][]string{{"01"}, {"02"}}, []bool{false, true})
+	shp := testShp([][]float64{square, square})
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range map[string][]byte{"sls/SLS.shp": shp, "sls/SLS.DBF": dbf, "sls/SLS.cpg": []byte("UTF-8")} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	layer, err := ReadShapefileZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()), "", 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer.Name != "SLS" || len(layer.Features) != 1 || layer.Features[0].Properties["IDSLS"] != "01" {
+		t.Errorf("layer %s, features %v", layer.Name, layer.Features)
+	}
+	if _, err := ReadShapefileZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()), "desa", 1<<20); err == nil {
+		t.Error("a missing layer must fail")
+	}
+	if _, err := ReadShapefileZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()), "", 100); err == nil {
+		t.Error("a file larger than the entry limit must fail")
+	}
+}
+
+func TestReadZipFileLimit(t *testing.T) {
+	// a deflated run of zeros whose header understates its size
+	var compressed bytes.Buffer
+	fw, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	fw.Write(make([]byte, 1<<20))
+	fw.Close()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "bomb.shp",
+		Method:             zip.Deflate,
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(compressed.Bytes())
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := zr.File[0]
+
+	tests := []struct {
+		name    string
+		size    uint64
+		maxSize int64
+		wantErr bool
+	}{
+		{name: "header over the limit", size: 1 << 20, maxSize: 1 << 10, wantErr: true},
+		{name: "content over the limit", size: 10, maxSize: 1 << 10, wantErr: true},
+		{name: "under the limit", size: 1 << 20, maxSize: 1 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f.UncompressedSize64 = tt.size
+			data, err := readZipFile(f, tt.maxSize)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("read %d bytes, want an error", len(data))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) != 1<<20 {
+				t.Errorf("read %d bytes, want %d", len(data), 1<<20)
+			}
+		})
+	}
+}
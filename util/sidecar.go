@@ -0,0 +1,29 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nahrx/geomatis-api/types"
+)
+
+// WritePrjFile writes the WKT of the world file CRS, read by GIS software
+// together with the world file.
+func WritePrjFile(filePath string, wkt string) error {
+	return os.WriteFile(filePath, []byte(wkt), 0644)
+}
+
+// xmlText escapes the text of an element, quotes are kept as GDAL writes them.
+var xmlText = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// WriteAuxXmlFile writes a GDAL PAM sidecar (<raster>.aux.xml) with the SRS and
+// the geotransform. The geotransform is anchored on the corner of the top left
+// pixel while the world file is anchored on its center.
+func WriteAuxXmlFile(filePath string, wkt string, p types.WorldFileParameter) error {
+	originX := p.C - p.A/2 - p.B/2
+	originY := p.F - p.D/2 - p.E/2
+	content := fmt.Sprintf("<PAMDataset>\n  <SRS>%s</SRS>\n  <GeoTransform>%24.16e,%24.16e,%24.16e,%24.16e,%24.16e,%24.16e</GeoTransform>\n</PAMDataset>\n",
+		xmlText.Replace(wkt), originX, p.A, p.B, originY, p.D, p.E)
+	return os.WriteFile(filePath, []byte(content), 0644)
+}
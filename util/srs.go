@@ -0,0 +1,105 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// WKT definitions bundled for the CRS used most with our master maps, so the
+// sidecars do not depend on the spatial_ref_sys content of the database.
+const (
+	wktWgs84Datum = `DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]]`
+	wktGreenwich  = `PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]]`
+	wktDegree     = `UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]]`
+	wktMetre      = `UNIT["metre",1,AUTHORITY["EPSG","9001"]]`
+	wktWgs84      = `GEOGCS["WGS 84",` + wktWgs84Datum + `,` + wktGreenwich + `,` + wktDegree + `,AUTHORITY["EPSG","4326"]]`
+)
+
+var spatialReferences = map[int]string{
+	4326: wktWgs84,
+	3857: `PROJCS["WGS 84 / Pseudo-Mercator",` + wktWgs84 + `,PROJECTION["Mercator_1SP"],PARAMETER["central_meridian",0],PARAMETER["scale_factor",1],PARAMETER["false_easting",0],PARAMETER["false_northing",0],` + wktMetre + `,AXIS["Easting",EAST],AXIS["Northing",NORTH],EXTENSION["PROJ4","+proj=merc +a=6378137 +b=6378137 +lat_ts=0 +lon_0=0 +x_0=0 +y_0=0 +k=1 +units=m +nadgrids=@null +wktext +no_defs"],AUTHORITY["EPSG","3857"]]`,
+	// DGN95, the Indonesian geodetic datum
+	4755: `GEOGCS["DGN95",DATUM["Datum_Geodesi_Nasional_1995",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],TOWGS84[0,0,0,0,0,0,0],AUTHORITY["EPSG","6755"]],` + wktGreenwich + `,` + wktDegree + `,AUTHORITY["EPSG","4755"]]`,
+}
+
+func init() {
+	// WGS 84 / UTM zone 1N - 60N (EPSG:32601 - 32660) and 1S - 60S (EPSG:32701 - 32760)
+	for zone := 1; zone <= 60; zone++ {
+		spatialReferences[32600+zone] = utmSpatialReference(zone, false)
+		spatialReferences[32700+zone] = utmSpatialReference(zone, true)
+	}
+}
+
+func utmSpatialReference(zone int, south bool) string {
+	hemisphere, falseNorthing, srid := "N", 0, 32600+zone
+	if south {
+		hemisphere, falseNorthing, srid = "S", 10000000, 32700+zone
+	}
+	return fmt.Sprintf(`PROJCS["WGS 84 / UTM zone %d%s",%s,PROJECTION["Transverse_Mercator"],PARAMETER["latitude_of_origin",0],PARAMETER["central_meridian",%d],PARAMETER["scale_factor",0.9996],PARAMETER["false_easting",500000],PARAMETER["false_northing",%d],%s,AXIS["Easting",EAST],AXIS["Northing",NORTH],AUTHORITY["EPSG","%d"]]`,
+		zone, hemisphere, wktWgs84, zone*6-183, falseNorthing, wktMetre, srid)
+}
+
+// SpatialReferenceWkt returns the bundled WKT of an EPSG code.
+func SpatialReferenceWkt(srid int) (string, bool) {
+	wkt, ok := spatialReferences[srid]
+	return wkt, ok
+}
+
+// esriSpatialReferences are the names ArcGIS writes in .prj files.
+var esriSpatialReferences = map[string]int{
+	"gcswgs1984":                        4326,
+	"wgs1984webmercatorauxiliarysphere": 3857,
+	"wgs1984webmercator":                3857,
+	"gcsdgn1995":                        4755,
+	"gcsdgn95":                          4755,
+}
+
+var wktAuthority = regexp.MustCompile(`AUTHORITY\["EPSG",\s*"?([0-9]+)"?\]\]\s*$`)
+var wktName = regexp.MustCompile(`^\s*(?:PROJCS|GEOGCS)\["([^"]+)"`)
+var utmName = regexp.MustCompile(`^wgs1984utmzone([0-9]{1,2})([ns])$`)
+
+// SridFromWkt finds the EPSG code of a .prj, from its authority or from the name
+// of a CRS bundled here.
+func SridFromWkt(wkt string) (int, bool) {
+	if m := wktAuthority.FindStringSubmatch(wkt); m != nil {
+		srid, err := strconv.Atoi(m[1])
+		return srid, err == nil
+	}
+	m := wktName.FindStringSubmatch(wkt)
+	if m == nil {
+		return 0, false
+	}
+	name := normalizeSrsName(m[1])
+	if srid, ok := esriSpatialReferences[name]; ok {
+		return srid, true
+	}
+	if m := utmName.FindStringSubmatch(name); m != nil {
+		zone, _ := strconv.Atoi(m[1])
+		if zone >= 1 && zone <= 60 {
+			if m[2] == "s" {
+				return 32700 + zone, true
+			}
+			return 32600 + zone, true
+		}
+	}
+	for srid, bundled := range spatialReferences {
+		if b := wktName.FindStringSubmatch(bundled); b != nil && normalizeSrsName(b[1]) == name {
+			return srid, true
+		}
+	}
+	return 0, false
+}
+
+// normalizeSrsName drops case and punctuation, "WGS 84 / UTM zone 50S" and
+// "WGS_84_UTM_Zone_50S" become the same name.
+func normalizeSrsName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}